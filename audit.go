@@ -0,0 +1,500 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Каждое изменение записи попадает в аудит: в таблицу (в той же транзакции, что и само изменение)
+// и/или в JSONL файл (после коммита, по строке на изменение). Таблицу нужно создать заранее:
+//
+//	CREATE TABLE audit_log (
+//	  id integer primary key autoincrement, -- AUTO_INCREMENT / serial в MySQL и PostgreSQL
+//	  created_at varchar(32) NOT NULL,
+//	  principal varchar(255) NOT NULL,
+//	  table_name varchar(255) NOT NULL,
+//	  record_id bigint NOT NULL,
+//	  operation varchar(16) NOT NULL,
+//	  before_data text,
+//	  after_data text
+//	);
+
+const (
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+	// opRestore - возврат мягко удалённой записи, Before у него пуст, как при создании
+	opRestore = "restore"
+)
+
+// auditTimeFormat фиксированной ширины, чтобы время в таблице можно было сравнивать как строки
+const auditTimeFormat = "2006-01-02T15:04:05.000000Z"
+
+// rowChange - одно изменение записи. Before пуст при создании, After - при удалении.
+// Database - имя базы в MultiDbExplorer: файл аудита у всех баз общий
+type rowChange struct {
+	Time      time.Time              `json:"time"`
+	Database  string                 `json:"database,omitempty"`
+	Principal string                 `json:"principal"`
+	Table     string                 `json:"table"`
+	ID        int64                  `json:"id"`
+	Operation string                 `json:"operation"`
+	Before    map[string]interface{} `json:"before"`
+	After     map[string]interface{} `json:"after"`
+}
+
+// auditFile - JSONL файл аудита, открывается один раз и только дописывается
+type auditFile struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (af *auditFile) write(changes []*rowChange) error {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+	for _, ch := range changes {
+		line, err := json.Marshal(ch)
+		if err != nil {
+			return err
+		}
+		_, err = af.file.Write(append(line, '\n'))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *DbExplorer) tracksChanges(table string) bool {
+	return e.Config.Audit.enabled() || e.Config.History.enabledFor(table)
+}
+
+func principalName(ctx context.Context) string {
+	if p := principalFromContext(ctx); p != nil {
+		return p.String()
+	}
+	return "anonymous"
+}
+
+// trackChange дочитывает состояние записи после изменения и пишет его в аудит и историю внутри транзакции
+func (e *DbExplorer) trackChange(ctx context.Context, tx *writeTx, tableInfo *TableInfo, op string, id int64, before map[string]interface{}) error {
+	// trackChange вызывается на каждое изменение записи, даже если аудит выключен: после коммита запись сбрасывается из кеша
+	tx.touched = append(tx.touched, changedRow{table: tableInfo.TableName, id: id})
+	if !e.tracksChanges(tableInfo.TableName) {
+		return nil
+	}
+	ch := &rowChange{
+		Time:      time.Now().UTC(),
+		Database:  e.Name,
+		Principal: principalName(ctx),
+		Table:     tableInfo.TableName,
+		ID:        id,
+		Operation: op,
+		Before:    before,
+	}
+	if op != opDelete {
+		after, err := e.queryRowById(ctx, tx, tableInfo, id)
+		if err != nil {
+			return err
+		}
+		ch.After = after
+	}
+	if e.Config.Audit.enabled() {
+		tx.changes = append(tx.changes, ch)
+	}
+	if e.Config.Audit.Table != "" {
+		err := e.insertChange(ctx, tx, e.Config.Audit.Table, ch)
+		if err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+	}
+	if e.Config.History.enabledFor(tableInfo.TableName) {
+		err := e.insertChange(ctx, tx, e.Config.History.Table, ch)
+		if err != nil {
+			return fmt.Errorf("history: %w", err)
+		}
+	}
+	return nil
+}
+
+// insertChange пишет изменение в таблицу-журнал: аудит и история устроены одинаково
+func (e *DbExplorer) insertChange(ctx context.Context, tx *writeTx, logTable string, ch *rowChange) error {
+	args := sqlArgs{dialect: e.Dialect}
+	columns := []string{"created_at", "principal", "table_name", "record_id", "operation", "before_data", "after_data"}
+	values := []interface{}{ch.Time.Format(auditTimeFormat), ch.Principal, ch.Table, ch.ID, ch.Operation, jsonOrNull(ch.Before), jsonOrNull(ch.After)}
+	quoted := make([]string, 0, len(columns))
+	placeholders := make([]string, 0, len(values))
+	for i, col := range columns {
+		quoted = append(quoted, e.Dialect.QuoteIdent(col))
+		placeholders = append(placeholders, args.add(values[i]))
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", e.Dialect.QuoteIdent(logTable),
+		strings.Join(quoted, ", "), strings.Join(placeholders, ", "))
+	_, err := tx.ExecContext(ctx, query, args.values...)
+	return err
+}
+
+func jsonOrNull(row map[string]interface{}) interface{} {
+	if row == nil {
+		return nil
+	}
+	data, _ := json.Marshal(row)
+	return string(data)
+}
+
+// afterCommit - изменения уже в базе, ошибку записи в файл остаётся только залогировать
+func (e *DbExplorer) afterCommit(changes []*rowChange) {
+	if e.Config.Audit.File == "" || len(changes) == 0 {
+		return
+	}
+	// файл открыт в конструкторе, см. Config.OpenFiles
+	err := e.Config.Audit.file.write(changes)
+	if err != nil {
+		e.Logger.Println("audit:", err)
+	}
+}
+
+// auditFilter - параметры GET /_audit
+type auditFilter struct {
+	Table     string
+	ID        *int64
+	Operation string
+	Principal string
+	Since     time.Time
+	Until     time.Time
+	Limit     int64
+	Offset    int64
+}
+
+func parseAuditFilter(r *http.Request, page PaginationConfig) (auditFilter, error) {
+	query := r.URL.Query()
+	f := auditFilter{
+		Table:     query.Get("table"),
+		Operation: query.Get("operation"),
+		Principal: query.Get("principal"),
+	}
+	if query.Has("id") {
+		id, err := strconv.ParseInt(query.Get("id"), 10, 64)
+		if err != nil {
+			return f, errors.New("bad id value")
+		}
+		f.ID = &id
+	}
+	for name, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		if !query.Has(name) {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, query.Get(name))
+		if err != nil {
+			return f, fmt.Errorf("bad %s value, RFC 3339 expected", name)
+		}
+		*dst = t.UTC()
+	}
+	page.AllowUnbounded = false
+	limit, offset, err := parsePage(query, page)
+	if err != nil {
+		return f, err
+	}
+	f.Limit, f.Offset = limit, offset
+	return f, nil
+}
+
+func (f auditFilter) match(ch *rowChange) bool {
+	return (f.Table == "" || ch.Table == f.Table) &&
+		(f.ID == nil || ch.ID == *f.ID) &&
+		(f.Operation == "" || ch.Operation == f.Operation) &&
+		(f.Principal == "" || ch.Principal == f.Principal) &&
+		(f.Since.IsZero() || !ch.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || ch.Time.Before(f.Until))
+}
+
+// handlerAudit отдаёт записи аудита, новые первыми. Если аудит пишется и в таблицу, и в файл, читаем таблицу.
+// Записи, которые автор запроса не должен видеть, пропускаются до отсчёта offset и limit
+func (e *DbExplorer) handlerAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendErrResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !e.Config.Audit.enabled() {
+		sendErrResponse(w, "audit is disabled", http.StatusNotFound)
+		return
+	}
+	f, err := parseAuditFilter(r, e.Config.Pagination)
+	if err != nil {
+		sendErrResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries := make([]*rowChange, 0)
+	if f.Limit == 0 {
+		// пустая страница, перебирать журнал незачем
+		sendResponse(w, map[string]interface{}{"response": map[string]interface{}{"entries": entries}}, http.StatusOK)
+		return
+	}
+	view := e.auditViewer(r)
+	skipped := int64(0)
+	err = e.eachAuditEntry(r.Context(), f, func(ch *rowChange) bool {
+		if ch = view(ch); ch == nil {
+			return true
+		}
+		if skipped < f.Offset {
+			skipped++
+			return true
+		}
+		entries = append(entries, ch)
+		return int64(len(entries)) < f.Limit
+	})
+	if err != nil {
+		e.logRequest(r, err)
+		sendErrResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendResponse(w, map[string]interface{}{"response": map[string]interface{}{"entries": entries}}, http.StatusOK)
+}
+
+// auditViewer возвращает функцию, которая показывает запись аудита так, как её видит автор запроса:
+// как в истории записи, таблица должна быть доступна на чтение по политике, закрытые колонки вырезаются,
+// а строки, не подходящие под построчную политику, пропускаются. nil - запись не показывается.
+// Записи таблиц, которых сейчас нет в схеме (или они скрыты настройками), не показываются никому
+func (e *DbExplorer) auditViewer(r *http.Request) func(ch *rowChange) *rowChange {
+	principal := principalFromContext(r.Context())
+	withPolicy := e.policy != nil && principal != nil
+	var roles []string
+	if withPolicy {
+		roles = e.policy.rolesOf(principal)
+	}
+	// tableAccess - запрос с решением политики и построчным фильтром для таблицы и её вид для автора запроса
+	type tableAccess struct {
+		r         *http.Request
+		tableInfo *TableInfo
+	}
+	tables := make(map[string]*tableAccess)
+	access := func(table string) *tableAccess {
+		if ta, ok := tables[table]; ok {
+			return ta
+		}
+		tables[table] = nil
+		tableInfo, exists := e.tableInfo(table)
+		if !exists {
+			return nil
+		}
+		tr := r
+		if withPolicy {
+			decision := e.policy.authorize(roles, table, http.MethodGet)
+			if !decision.Allowed {
+				return nil
+			}
+			tr = r.WithContext(context.WithValue(r.Context(), accessKey{}, decision))
+		}
+		tr, err := e.withRowFilter(tr, table)
+		if err != nil {
+			return nil
+		}
+		tables[table] = &tableAccess{r: tr, tableInfo: e.tableView(tr.Context(), tableInfo)}
+		return tables[table]
+	}
+	return func(ch *rowChange) *rowChange {
+		ta := access(ch.Table)
+		if ta == nil {
+			return nil
+		}
+		if (ch.Before != nil && !e.rowVisible(ta.r, ta.tableInfo, ch.Before)) || (ch.After != nil && !e.rowVisible(ta.r, ta.tableInfo, ch.After)) {
+			return nil
+		}
+		shown := *ch
+		shown.Before = e.restrictRow(ta.tableInfo, ch.Before)
+		shown.After = e.restrictRow(ta.tableInfo, ch.After)
+		return &shown
+	}
+}
+
+// auditBatchSize - сколько записей за раз читается из таблицы аудита, пока не наберётся страница
+const auditBatchSize = 500
+
+// eachAuditEntry перебирает записи этой базы, подходящие под фильтр, новые первыми, пока fn возвращает true.
+// Offset и Limit фильтра не учитываются: страницу отсчитывает fn
+func (e *DbExplorer) eachAuditEntry(ctx context.Context, f auditFilter, fn func(ch *rowChange) bool) error {
+	if e.Config.Audit.Table == "" {
+		return e.readAuditFile(f, fn)
+	}
+	batch := f
+	batch.Offset = 0
+	batch.Limit = f.Offset + f.Limit
+	if batch.Limit > auditBatchSize {
+		batch.Limit = auditBatchSize
+	}
+	for {
+		entries, err := e.queryChangeLog(ctx, e.Config.Audit.Table, batch, true)
+		if err != nil {
+			return err
+		}
+		for _, ch := range entries {
+			// у каждой базы своя таблица аудита
+			ch.Database = e.Name
+			if !fn(ch) {
+				return nil
+			}
+		}
+		if int64(len(entries)) < batch.Limit {
+			return nil
+		}
+		batch.Offset += batch.Limit
+	}
+}
+
+// queryChangeLog читает изменения из таблицы-журнала, newestFirst - новые первыми
+func (e *DbExplorer) queryChangeLog(ctx context.Context, logTable string, f auditFilter, newestFirst bool) ([]*rowChange, error) {
+	args := sqlArgs{dialect: e.Dialect}
+	conds := make([]string, 0)
+	addCond := func(column, op string, v interface{}) {
+		conds = append(conds, fmt.Sprintf("%s %s %s", e.Dialect.QuoteIdent(column), op, args.add(v)))
+	}
+	if f.Table != "" {
+		addCond("table_name", "=", f.Table)
+	}
+	if f.ID != nil {
+		addCond("record_id", "=", *f.ID)
+	}
+	if f.Operation != "" {
+		addCond("operation", "=", f.Operation)
+	}
+	if f.Principal != "" {
+		addCond("principal", "=", f.Principal)
+	}
+	if !f.Since.IsZero() {
+		addCond("created_at", ">=", f.Since.Format(auditTimeFormat))
+	}
+	if !f.Until.IsZero() {
+		addCond("created_at", "<", f.Until.Format(auditTimeFormat))
+	}
+	query := fmt.Sprintf("SELECT %s, %s, %s, %s, %s, %s, %s FROM %s",
+		e.Dialect.QuoteIdent("created_at"), e.Dialect.QuoteIdent("principal"), e.Dialect.QuoteIdent("table_name"),
+		e.Dialect.QuoteIdent("record_id"), e.Dialect.QuoteIdent("operation"), e.Dialect.QuoteIdent("before_data"),
+		e.Dialect.QuoteIdent("after_data"), e.Dialect.QuoteIdent(logTable))
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY " + e.Dialect.QuoteIdent("id")
+	if newestFirst {
+		query += " DESC"
+	}
+	query += e.Dialect.LimitOffset(args.add(f.Limit), args.add(f.Offset))
+	rows, err := e.reader(ctx).QueryContext(ctx, query, args.values...)
+	if err != nil {
+		return nil, err
+	}
+	values, err := scanStrings(rows)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*rowChange, 0, len(values))
+	for _, row := range values {
+		ch := &rowChange{
+			Principal: row[1].String,
+			Table:     row[2].String,
+			Operation: row[4].String,
+		}
+		ch.Time, _ = time.Parse(auditTimeFormat, row[0].String)
+		ch.ID, _ = strconv.ParseInt(row[3].String, 10, 64)
+		if row[5].Valid {
+			json.Unmarshal([]byte(row[5].String), &ch.Before)
+		}
+		if row[6].Valid {
+			json.Unmarshal([]byte(row[6].String), &ch.After)
+		}
+		entries = append(entries, ch)
+	}
+	return entries, nil
+}
+
+// readAuditFile читает файл с конца блоками, поэтому новые записи находятся, не читая файл целиком.
+// Это запасной вариант для инсталляций без таблицы аудита: фильтр проверяется по каждой строке
+func (e *DbExplorer) readAuditFile(f auditFilter, fn func(ch *rowChange) bool) error {
+	file, err := os.Open(e.Config.Audit.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	lines, err := newReverseLineReader(file)
+	if err != nil {
+		return err
+	}
+	for {
+		line, err := lines.next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ch := &rowChange{}
+		if json.Unmarshal(line, ch) != nil {
+			continue
+		}
+		if ch.Database == e.Name && f.match(ch) && !fn(ch) {
+			return nil
+		}
+	}
+}
+
+// reverseLineReader отдаёт строки файла от последней к первой
+type reverseLineReader struct {
+	file *os.File
+	// pos - сколько байт от начала файла ещё не прочитано
+	pos int64
+	// buf - прочитанный, но ещё не разобранный на строки кусок перед уже отданными строками
+	buf []byte
+}
+
+const reverseReadBlock = 64 * 1024
+
+func newReverseLineReader(file *os.File) (*reverseLineReader, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &reverseLineReader{file: file, pos: info.Size()}, nil
+}
+
+// next возвращает очередную непустую строку без перевода строки, она действительна до следующего вызова
+func (rl *reverseLineReader) next() ([]byte, error) {
+	for {
+		if i := bytes.LastIndexByte(rl.buf, '\n'); i >= 0 {
+			line := rl.buf[i+1:]
+			rl.buf = rl.buf[:i]
+			if len(line) > 0 {
+				return line, nil
+			}
+			continue
+		}
+		if rl.pos == 0 {
+			line := rl.buf
+			rl.buf = nil
+			if len(line) > 0 {
+				return line, nil
+			}
+			return nil, io.EOF
+		}
+		size := int64(reverseReadBlock)
+		if size > rl.pos {
+			size = rl.pos
+		}
+		chunk := make([]byte, size+int64(len(rl.buf)))
+		_, err := rl.file.ReadAt(chunk[:size], rl.pos-size)
+		if err != nil {
+			return nil, err
+		}
+		copy(chunk[size:], rl.buf)
+		rl.buf = chunk
+		rl.pos -= size
+	}
+}
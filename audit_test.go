@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReverseLineReader(t *testing.T) {
+	// строки длиннее блока чтения и переводы строк на границе блоков
+	lines := []string{"first", strings.Repeat("a", reverseReadBlock+10), "", "third", strings.Repeat("b", reverseReadBlock-6), "last"}
+	filePath := filepath.Join(t.TempDir(), "audit.jsonl")
+	err := os.WriteFile(filePath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rl, err := newReverseLineReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"last", lines[4], "third", lines[1], "first"}
+	for _, w := range want {
+		line, err := rl.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(line) != w {
+			t.Fatalf("got line of %d bytes, want %d bytes", len(line), len(w))
+		}
+	}
+	if _, err = rl.next(); !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Ключи хранятся только в виде sha256, сами ключи explorer не знает.
+// Хеш ключа для конфига можно получить так: printf '%s' "$KEY" | sha256sum
+
+// Principal - тот, от чьего имени выполняется запрос
+type Principal struct {
+	// Label - метка ключа или sub из JWT
+	Label string
+	// Claims и Scopes есть только у JWT, API-ключ даёт полный доступ
+	Claims map[string]interface{}
+	Scopes []string
+}
+
+func (p *Principal) String() string {
+	if p.Claims != nil {
+		return "jwt " + p.Label
+	}
+	return "key " + p.Label
+}
+
+// hasScope - scope вида items:read, в токене можно писать шаблоны: *:read, *
+func (p *Principal) hasScope(scope string) bool {
+	if p.Claims == nil {
+		return true
+	}
+	return matchAny(p.Scopes, scope)
+}
+
+// routeScope - какой scope нужен для запроса. Пустая строка - достаточно быть аутентифицированным
+func routeScope(method, urlPath string) string {
+	name := routeTable(urlPath)
+	switch {
+	case name == "":
+		return ""
+	case name == "_admin" || name == "_audit" || name == "_dump":
+		return "admin"
+	case method == http.MethodGet || method == http.MethodHead:
+		return name + ":read"
+	}
+	return name + ":write"
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFromContext возвращает nil, если аутентификация выключена
+func principalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+type apiKey struct {
+	label string
+	hash  [sha256.Size]byte
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseAPIKey(label, hash string) (apiKey, error) {
+	key := apiKey{label: label}
+	if label == "" {
+		return key, errors.New("label is required")
+	}
+	raw, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(hash), "sha256:"))
+	if err != nil || len(raw) != sha256.Size {
+		return key, fmt.Errorf("key %s: hash must be hex encoded sha256", label)
+	}
+	copy(key.hash[:], raw)
+	return key, nil
+}
+
+// matchAPIKey сравнивает хеш предъявленного ключа со всеми известными за одинаковое время,
+// чтобы по времени ответа нельзя было подобрать ни ключ, ни его позицию в списке
+func matchAPIKey(keys []apiKey, presented string) (*apiKey, bool) {
+	sum := sha256.Sum256([]byte(presented))
+	var found *apiKey
+	for i := range keys {
+		if subtle.ConstantTimeCompare(sum[:], keys[i].hash[:]) == 1 && found == nil {
+			found = &keys[i]
+		}
+	}
+	return found, found != nil
+}
+
+// requestAPIKey достаёт ключ или токен из Authorization: Bearer или X-API-Key
+func requestAPIKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// loadAPIKeys собирает ключи из конфига и, если задана, из таблицы с колонками label и key_hash
+func (e *DbExplorer) loadAPIKeys() ([]apiKey, error) {
+	keys := make([]apiKey, 0, len(e.Config.Auth.APIKeys))
+	for _, kc := range e.Config.Auth.APIKeys {
+		key, err := parseAPIKey(kc.Label, kc.Hash)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if e.Config.Auth.APIKeysTable == "" {
+		return keys, nil
+	}
+	query := fmt.Sprintf("SELECT %s, %s FROM %s", e.Dialect.QuoteIdent("label"), e.Dialect.QuoteIdent("key_hash"),
+		e.Dialect.QuoteIdent(e.Config.Auth.APIKeysTable))
+	rows, err := e.Db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("api keys table: %w", err)
+	}
+	values, err := scanStrings(rows)
+	if err != nil {
+		return nil, fmt.Errorf("api keys table: %w", err)
+	}
+	for _, row := range values {
+		key, err := parseAPIKey(row[0].String, row[1].String)
+		if err != nil {
+			return nil, fmt.Errorf("api keys table: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (e *DbExplorer) authEnabled() bool {
+	return e.Config.Auth.enabled()
+}
+
+// authenticate проверяет ключ или JWT запроса, текст ошибки уходит клиенту как есть
+func (e *DbExplorer) authenticate(r *http.Request) (*Principal, error) {
+	presented := requestAPIKey(r)
+	if presented == "" {
+		return nil, errors.New("authorization required")
+	}
+	if e.jwt != nil && looksLikeJWT(presented) {
+		claims, err := e.jwt.verify(presented)
+		if err != nil {
+			return nil, fmt.Errorf("invalid token: %w", err)
+		}
+		return e.jwt.principal(claims), nil
+	}
+	e.schemaMu.RLock()
+	keys := e.apiKeys
+	e.schemaMu.RUnlock()
+	key, ok := matchAPIKey(keys, presented)
+	if !ok {
+		return nil, errors.New("invalid api key")
+	}
+	return &Principal{Label: key.label}, nil
+}
+
+func sendUnauthorized(w http.ResponseWriter, text string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="db_explorer"`)
+	sendErrResponse(w, text, http.StatusUnauthorized)
+}
+
+// logRequest пишет в лог сообщение, помеченное ключом или субъектом токена, с которым пришёл запрос
+func (e *DbExplorer) logRequest(r *http.Request, v ...interface{}) {
+	if p := principalFromContext(r.Context()); p != nil {
+		v = append([]interface{}{"[" + p.String() + "]"}, v...)
+	}
+	e.Logger.Output(2, fmt.Sprintln(v...))
+}
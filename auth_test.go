@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMatchAPIKey(t *testing.T) {
+	keys := make([]apiKey, 0)
+	for label, key := range map[string]string{"ci": "one", "admin": "two"} {
+		k, err := parseAPIKey(label, hashAPIKey(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	if key, ok := matchAPIKey(keys, "two"); !ok || key.label != "admin" {
+		t.Errorf("key two not matched: %v %v", key, ok)
+	}
+	if _, ok := matchAPIKey(keys, "three"); ok {
+		t.Error("unknown key matched")
+	}
+	if _, ok := matchAPIKey(keys, ""); ok {
+		t.Error("empty key matched")
+	}
+
+	if _, err := parseAPIKey("bad", "not-a-hash"); err == nil {
+		t.Error("expected error for bad hash")
+	}
+	if _, err := parseAPIKey("prefixed", "sha256:"+hashAPIKey("x")); err != nil {
+		t.Errorf("prefixed hash rejected: %v", err)
+	}
+}
+
+func TestRequestAPIKey(t *testing.T) {
+	cases := []struct {
+		headers map[string]string
+		want    string
+	}{
+		{map[string]string{"Authorization": "Bearer abc"}, "abc"},
+		{map[string]string{"Authorization": "bearer  abc "}, "abc"},
+		{map[string]string{"Authorization": "Basic abc", "X-API-Key": "xyz"}, "xyz"},
+		{map[string]string{"X-API-Key": "xyz"}, "xyz"},
+		{map[string]string{}, ""},
+	}
+	for _, c := range cases {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range c.headers {
+			r.Header.Set(k, v)
+		}
+		if got := requestAPIKey(r); got != c.want {
+			t.Errorf("headers %v: got %q, want %q", c.headers, got, c.want)
+		}
+	}
+}
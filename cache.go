@@ -0,0 +1,240 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Кеш чтений: записи по первичному ключу и страницы списков, ограничен по числу ответов (LRU) и по времени жизни.
+// Изменение записи через explorer после коммита сбрасывает её саму и все страницы списков её таблицы.
+// Изменения в базе мимо explorer'а видны только по истечении TTL, ?nocache=1 читает из базы в обход кеша.
+// Ключ включает всё, от чего зависит ответ: видимые колонки, условия построчной политики и include_deleted
+
+type readCache struct {
+	mu       sync.Mutex
+	capacity int
+	lru      *list.List
+	items    map[string]*list.Element
+	// byTable - элементы таблицы, чтобы сбрасывать их, не перебирая весь кеш
+	byTable map[string]map[*list.Element]bool
+	// gens растёт при каждом сбросе таблицы: ответ, прочитанный до сброса, в кеш уже не кладём
+	gens map[string]uint64
+
+	hits, misses, evictions, invalidations int64
+}
+
+type cacheEntry struct {
+	key   string
+	table string
+	// id - первичный ключ записи, для страницы списка list = true
+	id      int64
+	list    bool
+	value   interface{}
+	expires time.Time
+}
+
+func newReadCache(capacity int) *readCache {
+	return &readCache{
+		capacity: capacity,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+		byTable:  make(map[string]map[*list.Element]bool),
+		gens:     make(map[string]uint64),
+	}
+}
+
+func (c *readCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if ok && time.Now().After(el.Value.(*cacheEntry).expires) {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry).value, true
+}
+
+func (c *readCache) generation(table string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gens[table]
+}
+
+// set кладёт ответ, если с момента gen таблицу никто не менял
+func (c *readCache) set(entry *cacheEntry, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gens[entry.table] != gen {
+		return
+	}
+	if el, ok := c.items[entry.key]; ok {
+		c.remove(el)
+	}
+	el := c.lru.PushFront(entry)
+	c.items[entry.key] = el
+	if c.byTable[entry.table] == nil {
+		c.byTable[entry.table] = make(map[*list.Element]bool)
+	}
+	c.byTable[entry.table][el] = true
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+// remove вызывается под c.mu
+func (c *readCache) remove(el *list.Element) {
+	entry := el.Value.(*cacheEntry)
+	c.lru.Remove(el)
+	delete(c.items, entry.key)
+	delete(c.byTable[entry.table], el)
+}
+
+// invalidate сбрасывает запись id и все страницы списков таблицы
+func (c *readCache) invalidate(table string, id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[table]++
+	for el := range c.byTable[table] {
+		entry := el.Value.(*cacheEntry)
+		if entry.list || entry.id == id {
+			c.remove(el)
+			c.invalidations++
+		}
+	}
+}
+
+func (c *readCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for table := range c.byTable {
+		c.gens[table]++
+	}
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	c.byTable = make(map[string]map[*list.Element]bool)
+}
+
+func (c *readCache) stats() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]interface{}{
+		"size":          c.lru.Len(),
+		"capacity":      c.capacity,
+		"hits":          c.hits,
+		"misses":        c.misses,
+		"evictions":     c.evictions,
+		"invalidations": c.invalidations,
+	}
+}
+
+// changedRow - запись, изменённая в транзакции, после коммита её нужно сбросить из кеша
+type changedRow struct {
+	table string
+	id    int64
+}
+
+func (e *DbExplorer) invalidateCache(rows []changedRow) {
+	if e.cache == nil {
+		return
+	}
+	for _, row := range rows {
+		e.cache.invalidate(row.table, row.id)
+	}
+}
+
+// cacheKey - ключ ответа для автора запроса, false - такой ответ не кешируем
+func (e *DbExplorer) cacheKey(r *http.Request, tableInfo *TableInfo, what string) (string, bool) {
+	conds, err := e.rowConditions(r.Context(), tableInfo)
+	if err != nil {
+		return "", false
+	}
+	columns := make([]string, 0, len(tableInfo.Fields))
+	for _, fldInfo := range tableInfo.Fields {
+		columns = append(columns, fldInfo.Field)
+	}
+	_, includeDeleted := r.Context().Value(includeDeletedKey{}).(bool)
+	return fmt.Sprintf("%s|%s|%s|%#v|%t", tableInfo.TableName, what, strings.Join(columns, ","), conds, includeDeleted), true
+}
+
+// readThrough отдаёт ответ из кеша или читает его через load и кладёт в кеш. В X-Cache пишем, откуда ответ
+// what - что читаем внутри таблицы, entry - к какой записи относится ответ
+func (e *DbExplorer) readThrough(w http.ResponseWriter, r *http.Request, tableInfo *TableInfo, what string, entry cacheEntry, load func() (interface{}, error)) (interface{}, error) {
+	ttl := e.Config.cacheTTL(tableInfo.TableName)
+	if e.cache == nil || ttl < 0 {
+		return load()
+	}
+	if r.URL.Query().Get("nocache") == "1" {
+		w.Header().Set("X-Cache", "BYPASS")
+		return load()
+	}
+	key, ok := e.cacheKey(r, tableInfo, what)
+	if !ok {
+		return load()
+	}
+	if value, ok := e.cache.get(key); ok {
+		w.Header().Set("X-Cache", "HIT")
+		return value, nil
+	}
+	w.Header().Set("X-Cache", "MISS")
+	gen := e.cache.generation(tableInfo.TableName)
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	entry.key, entry.table, entry.value, entry.expires = key, tableInfo.TableName, value, time.Now().Add(ttl)
+	e.cache.set(&entry, gen)
+	return value, nil
+}
+
+func (e *DbExplorer) cachedRow(w http.ResponseWriter, r *http.Request, tableInfo *TableInfo, id int64) (map[string]interface{}, error) {
+	value, err := e.readThrough(w, r, tableInfo, fmt.Sprintf("id=%d", id), cacheEntry{id: id}, func() (interface{}, error) {
+		return e.getRowFromTableById(r.Context(), tableInfo, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(map[string]interface{}), nil
+}
+
+// cachedRows - страница списка, limit -1 - вся таблица
+func (e *DbExplorer) cachedRows(w http.ResponseWriter, r *http.Request, tableInfo *TableInfo, limit, offset int64) ([]map[string]interface{}, error) {
+	value, err := e.readThrough(w, r, tableInfo, fmt.Sprintf("limit=%d&offset=%d", limit, offset), cacheEntry{list: true}, func() (interface{}, error) {
+		if limit < 0 {
+			return e.getAllRowsFromTable(r.Context(), tableInfo)
+		}
+		return e.getRowsFromTableByLimitAndOffset(r.Context(), tableInfo, limit, offset)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]map[string]interface{}), nil
+}
+
+// handlerCache - GET /_admin/cache отдаёт счётчики кеша, DELETE сбрасывает его целиком
+func (e *DbExplorer) handlerCache(w http.ResponseWriter, r *http.Request) {
+	if e.cache == nil {
+		sendErrResponse(w, "cache is disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		e.cache.purge()
+		e.logRequest(r, "cache purged")
+	default:
+		sendErrResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sendResponse(w, map[string]interface{}{"response": e.cache.stats()}, http.StatusOK)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func cacheTestEntry(key, table string, id int64, list bool, ttl time.Duration) *cacheEntry {
+	return &cacheEntry{key: key, table: table, id: id, list: list, value: key, expires: time.Now().Add(ttl)}
+}
+
+func TestReadCacheLRU(t *testing.T) {
+	c := newReadCache(2)
+	c.set(cacheTestEntry("a", "items", 1, false, time.Minute), 0)
+	c.set(cacheTestEntry("b", "items", 2, false, time.Minute), 0)
+	// a свежее b, вытесняться должна b
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a must be cached")
+	}
+	c.set(cacheTestEntry("c", "items", 3, false, time.Minute), 0)
+	if _, ok := c.get("b"); ok {
+		t.Error("b must be evicted")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("a must stay cached")
+	}
+	stats := c.stats()
+	if stats["size"] != 2 || stats["evictions"] != int64(1) || stats["hits"] != int64(2) || stats["misses"] != int64(1) {
+		t.Errorf("bad stats %v", stats)
+	}
+
+	c.set(cacheTestEntry("expired", "items", 4, false, -time.Second), 0)
+	if _, ok := c.get("expired"); ok {
+		t.Error("expired entry must not be returned")
+	}
+}
+
+func TestReadCacheInvalidate(t *testing.T) {
+	c := newReadCache(10)
+	c.set(cacheTestEntry("row1", "items", 1, false, time.Minute), 0)
+	c.set(cacheTestEntry("row2", "items", 2, false, time.Minute), 0)
+	c.set(cacheTestEntry("list", "items", 0, true, time.Minute), 0)
+	c.set(cacheTestEntry("user1", "users", 1, false, time.Minute), 0)
+
+	gen := c.generation("items")
+	c.invalidate("items", 1)
+	for key, cached := range map[string]bool{"row1": false, "row2": true, "list": false, "user1": true} {
+		if _, ok := c.get(key); ok != cached {
+			t.Errorf("%s: expected cached %t", key, cached)
+		}
+	}
+
+	// прочитано до изменения - в кеш не попадает
+	c.set(cacheTestEntry("row1", "items", 1, false, time.Minute), gen)
+	if _, ok := c.get("row1"); ok {
+		t.Error("stale read must not be cached")
+	}
+
+	c.purge()
+	if _, ok := c.get("user1"); ok {
+		t.Error("purge must drop everything")
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type columnKind int
+
+const (
+	kindString columnKind = iota
+	kindInteger
+	kindFloat
+	// kindBool - boolean PostgreSQL и SQLite, в MySQL BOOLEAN - это tinyint(1), то есть целое
+	kindBool
+)
+
+// columnType - разобранное описание типа колонки, которое получаем из FieldInfo.Type
+type columnType struct {
+	Kind      columnKind
+	MaxLength int
+	Enum      []string
+	Nullable  bool
+	Unsigned  bool
+}
+
+// размеры текстовых типов, у которых длина не указывается в скобках
+var textTypeLengths = map[string]int{
+	"tinytext":   255,
+	"text":       65535,
+	"mediumtext": 16777215,
+	"longtext":   4294967295,
+}
+
+// имена целочисленных и дробных типов MySQL, PostgreSQL и SQLite без размеров и модификаторов
+var (
+	integerTypes = map[string]bool{
+		"tinyint": true, "smallint": true, "mediumint": true, "int": true, "integer": true, "bigint": true,
+		"int2": true, "int4": true, "int8": true, "serial": true, "smallserial": true, "bigserial": true,
+	}
+	floatTypes = map[string]bool{
+		"float": true, "double": true, "decimal": true, "dec": true, "numeric": true, "fixed": true, "real": true,
+		"float4": true, "float8": true,
+	}
+)
+
+// baseType отрезает от типа размеры и модификаторы: int(11) unsigned -> int
+func baseType(t string) string {
+	if i := strings.IndexAny(t, "( "); i != -1 {
+		return t[:i]
+	}
+	return t
+}
+
+func (fi *FieldInfo) columnType() columnType {
+	t := strings.ToLower(fi.Type)
+	base := baseType(t)
+	ct := columnType{
+		Nullable: fi.Null == "YES",
+		Unsigned: strings.Contains(t, "unsigned"),
+	}
+	switch {
+	case base == "enum":
+		ct.Kind = kindString
+		ct.Enum = parseEnumValues(fi.Type[strings.Index(fi.Type, "(")+1 : strings.LastIndex(fi.Type, ")")])
+	case strings.Contains(base, "char") || strings.Contains(base, "text"):
+		ct.Kind = kindString
+		if l, ok := textTypeLengths[t]; ok {
+			ct.MaxLength = l
+		} else {
+			ct.MaxLength = typeLength(t)
+		}
+	case integerTypes[base]:
+		ct.Kind = kindInteger
+	case floatTypes[base]:
+		ct.Kind = kindFloat
+	case base == "bool" || base == "boolean":
+		ct.Kind = kindBool
+	default:
+		// даты, json и прочее передаём строкой, проверку формата оставляем базе
+		ct.Kind = kindString
+	}
+	return ct
+}
+
+var (
+	errInvalidType  = errors.New("have invalid type")
+	errInvalidValue = errors.New("have invalid value")
+	errTooLong      = errors.New("is too long")
+	errReadOnly     = errors.New("is read only")
+)
+
+// normalize проверяет значение, пришедшее из json, и приводит его к виду, в котором оно уйдёт в базу.
+// json распаковывает все числа во float64, поэтому целые проверяем на отсутствие дробной части
+func (ct columnType) normalize(v interface{}) (interface{}, error) {
+	if v == nil {
+		if ct.Nullable {
+			return nil, nil
+		}
+		return nil, errInvalidType
+	}
+	switch ct.Kind {
+	case kindString:
+		str, ok := v.(string)
+		if !ok {
+			return nil, errInvalidType
+		}
+		if ct.MaxLength > 0 && utf8.RuneCountInString(str) > ct.MaxLength {
+			return nil, errTooLong
+		}
+		if len(ct.Enum) > 0 && !containsString(ct.Enum, str) {
+			return nil, errInvalidValue
+		}
+		return str, nil
+	case kindInteger:
+		var num float64
+		switch val := v.(type) {
+		case float64:
+			num = val
+		case int:
+			num = float64(val)
+		case int64:
+			num = float64(val)
+		default:
+			return nil, errInvalidType
+		}
+		if num != math.Trunc(num) {
+			return nil, errInvalidType
+		}
+		if ct.Unsigned && num < 0 {
+			return nil, errInvalidValue
+		}
+		return int64(num), nil
+	case kindFloat:
+		var num float64
+		switch val := v.(type) {
+		case float64:
+			num = val
+		case float32:
+			num = float64(val)
+		case int:
+			num = float64(val)
+		default:
+			return nil, errInvalidType
+		}
+		if ct.Unsigned && num < 0 {
+			return nil, errInvalidValue
+		}
+		return num, nil
+	case kindBool:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errInvalidType
+		}
+		return b, nil
+	}
+	return nil, errInvalidType
+}
+
+// decode приводит значение, прочитанное из базы, к типу, который отдаём в json
+func (ct columnType) decode(val interface{}) interface{} {
+	var raw string
+	switch data := val.(type) {
+	case nil:
+		return nil
+	case []byte:
+		raw = string(data)
+	case string:
+		raw = data
+	default:
+		return data
+	}
+	switch ct.Kind {
+	case kindInteger:
+		intVal, _ := strconv.ParseInt(raw, 10, 64)
+		return intVal
+	case kindFloat:
+		fltVal, _ := strconv.ParseFloat(raw, 64)
+		return fltVal
+	case kindBool:
+		// PostgreSQL в текстовом виде отдаёт t и f, ParseBool понимает и их
+		boolVal, _ := strconv.ParseBool(raw)
+		return boolVal
+	}
+	return raw
+}
+
+// zeroValue - значение для поля, которое не передали при создании записи
+func (ct columnType) zeroValue() interface{} {
+	if ct.Nullable {
+		return nil
+	}
+	switch ct.Kind {
+	case kindInteger:
+		return 0
+	case kindFloat:
+		return 0.0
+	case kindBool:
+		return false
+	}
+	if len(ct.Enum) > 0 {
+		return ct.Enum[0]
+	}
+	return ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// typeLength возвращает число из скобок, например 255 для varchar(255)
+func typeLength(t string) int {
+	start := strings.Index(t, "(")
+	end := strings.Index(t, ")")
+	if start == -1 || end < start {
+		return 0
+	}
+	l, err := strconv.Atoi(t[start+1 : end])
+	if err != nil {
+		return 0
+	}
+	return l
+}
+
+// parseEnumValues разбирает список вида 'a','b','it”s'
+func parseEnumValues(list string) []string {
+	values := make([]string, 0)
+	var cur strings.Builder
+	inQuotes := false
+	for i := 0; i < len(list); i++ {
+		ch := list[i]
+		switch {
+		case ch == '\'' && inQuotes && i+1 < len(list) && list[i+1] == '\'':
+			cur.WriteByte('\'')
+			i++
+		case ch == '\'':
+			inQuotes = !inQuotes
+			if !inQuotes {
+				values = append(values, cur.String())
+				cur.Reset()
+			}
+		case inQuotes:
+			cur.WriteByte(ch)
+		}
+	}
+	return values
+}
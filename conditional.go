@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Условные GET для записей и списков: ETag (хеш ответа), Last-Modified (по колонке updated_at или
+// table_settings.last_modified_column) и Cache-Control из настроек. Если клиент прислал If-None-Match
+// или If-Modified-Since и ответ не изменился, отдаём 304 без тела
+
+// rowTimeLayouts - в каком виде базы отдают даты, если драйвер не разбирает их в time.Time
+var rowTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parseRowTime - время без зоны считаем UTC
+func parseRowTime(v interface{}) (time.Time, bool) {
+	switch val := v.(type) {
+	case time.Time:
+		return val.UTC(), !val.IsZero()
+	case string:
+		for _, layout := range rowTimeLayouts {
+			if t, err := time.Parse(layout, val); err == nil {
+				return t.UTC(), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// lastModified - самое позднее время изменения среди записей, нулевое, если его не узнать
+func (e *DbExplorer) lastModified(tableInfo *TableInfo, rows ...map[string]interface{}) time.Time {
+	column := e.Config.lastModifiedColumn(tableInfo.TableName)
+	if tableInfo.getFieldInfoByName(column) == nil {
+		return time.Time{}
+	}
+	var latest time.Time
+	for _, row := range rows {
+		t, ok := parseRowTime(row[column])
+		if !ok {
+			// у части записей времени нет - для всего ответа его тоже нет
+			return time.Time{}
+		}
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// etagMatchesWeak - для If-None-Match метки сравниваются без учёта W/
+func etagMatchesWeak(tags []string, etag string) bool {
+	for _, tag := range tags {
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCacheHeaders выставляет заголовки кеширования и отвечает 304, если у клиента актуальная версия.
+// etag - метка содержимого, к ней добавляется формат ответа из formatETag. true - ответ уже отправлен
+func (e *DbExplorer) writeCacheHeaders(w http.ResponseWriter, r *http.Request, table string, etag string, lastModified time.Time) bool {
+	etag = formatETag(etag, formatOf(w))
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+	if cc := e.Config.cacheControl(table); cc != "" {
+		w.Header().Set("Cache-Control", cc)
+	}
+	if e.authEnabled() {
+		// ответ зависит от того, кто спрашивает
+		w.Header().Add("Vary", "Authorization, X-API-Key")
+	}
+	notModified := false
+	if inm := r.Header.Values("If-None-Match"); len(inm) > 0 {
+		// If-Modified-Since при If-None-Match не учитывается
+		notModified = etagMatchesWeak(splitETags(inm), etag)
+	} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.IsZero() {
+		notModified = !lastModified.Truncate(time.Second).After(ims)
+	}
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
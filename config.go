@@ -0,0 +1,776 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config собирается в таком порядке (каждый следующий источник перекрывает предыдущий):
+// значения по умолчанию, файл (yaml или json, путь из -config или DB_EXPLORER_CONFIG),
+// переменные окружения DB_EXPLORER_*, флаги командной строки
+type Config struct {
+	Driver string `yaml:"driver"`
+	DSN    string `yaml:"dsn"`
+	// ReadOnlyDSN - необязательное подключение для GET запросов, например пользователем без прав на запись
+	ReadOnlyDSN string           `yaml:"read_only_dsn"`
+	Databases   []DatabaseConfig `yaml:"databases"`
+
+	Listen   string         `yaml:"listen"`
+	TLS      TLSConfig      `yaml:"tls"`
+	Pool     PoolConfig     `yaml:"pool"`
+	Timeouts TimeoutsConfig `yaml:"timeouts"`
+
+	Pagination PaginationConfig `yaml:"pagination"`
+	// Tables - какие таблицы отдавать наружу
+	Tables TablesFilter `yaml:"tables"`
+	// TableSettings - настройки отдельных таблиц, перекрывают общие
+	TableSettings map[string]TableConfig `yaml:"table_settings"`
+	Log           LogConfig              `yaml:"log"`
+	Auth          AuthConfig             `yaml:"auth"`
+	Audit         AuditConfig            `yaml:"audit"`
+	History       HistoryConfig          `yaml:"history"`
+	Cache         CacheConfig            `yaml:"cache"`
+	CSV           CSVConfig              `yaml:"csv"`
+	Import        ImportConfig           `yaml:"import"`
+	Dump          DumpConfig             `yaml:"dump"`
+
+	// SchemaPollInterval - как часто проверять, не поменялась ли схема базы, 0 - не проверять
+	SchemaPollInterval time.Duration `yaml:"schema_poll_interval"`
+
+	// ReadOnly запрещает PUT, POST, PATCH и DELETE для всех таблиц, отвечаем 503
+	ReadOnly bool `yaml:"read_only"`
+	// DisabledMethods - http-методы, которые отключены целиком
+	DisabledMethods []string `yaml:"disabled_methods"`
+	// RequireIfMatch - обновлять и удалять записи можно только с заголовком If-Match, иначе 428
+	RequireIfMatch bool `yaml:"require_if_match"`
+	// CacheControl - заголовок Cache-Control для списков и записей, пустой - не отдаём
+	CacheControl string `yaml:"cache_control"`
+
+	// filesMu - explorer'ы с общим конфигом открывают его файлы по очереди. Указатель, чтобы копии из forDatabase
+	// делили его с исходным конфигом, создаётся в DefaultConfig
+	filesMu *sync.Mutex
+}
+
+// DatabaseConfig - одна из именованных баз, доступных по /db/$database/$table
+type DatabaseConfig struct {
+	Name        string `yaml:"name"`
+	Driver      string `yaml:"driver"`
+	DSN         string `yaml:"dsn"`
+	ReadOnlyDSN string `yaml:"read_only_dsn"`
+	// TableSettings - настройки таблиц этой базы, настройки таблицы целиком заменяют общие table_settings с тем же именем
+	TableSettings map[string]TableConfig `yaml:"table_settings"`
+}
+
+// forDatabase - конфиг для explorer'а базы name: общий, если у базы нет своих table_settings, иначе копия
+// с объединёнными table_settings. Файлы лога и аудита у копии те же
+func (c *Config) forDatabase(name string) *Config {
+	for _, d := range c.Databases {
+		if d.Name != name || len(d.TableSettings) == 0 {
+			continue
+		}
+		dbCfg := *c
+		dbCfg.TableSettings = make(map[string]TableConfig, len(c.TableSettings)+len(d.TableSettings))
+		for table, tc := range c.TableSettings {
+			dbCfg.TableSettings[table] = tc
+		}
+		for table, tc := range d.TableSettings {
+			dbCfg.TableSettings[table] = tc
+		}
+		return &dbCfg
+	}
+	return c
+}
+
+// AuthConfig - если не заданы ни ключи, ни таблица ключей, ни JWT, explorer открыт всем
+type AuthConfig struct {
+	// APIKeys - ключи с метками, метка попадает в лог вместе с запросом
+	APIKeys []APIKeyConfig `yaml:"api_keys"`
+	// APIKeysTable - таблица с колонками label и key_hash, наружу она не отдаётся
+	APIKeysTable string    `yaml:"api_keys_table"`
+	JWT          JWTConfig `yaml:"jwt"`
+	// PolicyFile - файл с ролями и правилами доступа к таблицам, см. policy.go
+	PolicyFile string `yaml:"policy_file"`
+}
+
+// JWTConfig - токены проверяются по секрету (HS256), открытому ключу в PEM (RS256, ES256)
+// или ключам из локального JWKS. Issuer и Audience проверяются, только если заданы
+type JWTConfig struct {
+	Secret        string `yaml:"secret"`
+	PublicKeyFile string `yaml:"public_key_file"`
+	JWKSFile      string `yaml:"jwks_file"`
+	Issuer        string `yaml:"issuer"`
+	Audience      string `yaml:"audience"`
+	// ScopesClaim - claim со списком scope (строка через пробел или массив), по умолчанию scope
+	ScopesClaim string `yaml:"scopes_claim"`
+}
+
+func (jc JWTConfig) enabled() bool {
+	return jc.Secret != "" || jc.PublicKeyFile != "" || jc.JWKSFile != ""
+}
+
+// APIKeyConfig - в конфиге лежит только hex sha256 от ключа
+type APIKeyConfig struct {
+	Label string `yaml:"label"`
+	Hash  string `yaml:"hash"`
+}
+
+func (ac AuthConfig) enabled() bool {
+	return len(ac.APIKeys) > 0 || ac.APIKeysTable != "" || ac.JWT.enabled()
+}
+
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type PoolConfig struct {
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+}
+
+type TimeoutsConfig struct {
+	Read  time.Duration `yaml:"read"`
+	Write time.Duration `yaml:"write"`
+	Idle  time.Duration `yaml:"idle"`
+	// Query ограничивает время обработки одного запроса к explorer, 0 - без ограничения
+	Query time.Duration `yaml:"query"`
+}
+
+type PaginationConfig struct {
+	DefaultLimit int `yaml:"default_limit"`
+	// MaxLimit - больше этого числа записей за раз не отдаём, 0 - без ограничения
+	MaxLimit int `yaml:"max_limit"`
+	// AllowUnbounded разрешает запрашивать всю таблицу целиком через limit=all
+	AllowUnbounded bool `yaml:"allow_unbounded"`
+}
+
+// TablesFilter - имена или glob-шаблоны (path.Match) таблиц.
+// Пустой Include - отдаём все таблицы, кроме попавших в Exclude
+type TablesFilter struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// UnmarshalYAML позволяет писать просто tables: [items, users] вместо tables: {include: [...]}
+func (f *TablesFilter) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		return value.Decode(&f.Include)
+	}
+	type plain TablesFilter
+	return value.Decode((*plain)(f))
+}
+
+type TableConfig struct {
+	Pagination TablePaginationConfig `yaml:"pagination"`
+	// HiddenColumns не читаются и не пишутся, как будто их нет в таблице
+	HiddenColumns []string `yaml:"hidden_columns"`
+	// ReadOnlyColumns отдаются при чтении, но задавать их при создании и обновлении нельзя
+	ReadOnlyColumns []string `yaml:"read_only_columns"`
+	// WriteOnlyColumns можно задать, но при чтении они не отдаются
+	WriteOnlyColumns []string `yaml:"write_only_columns"`
+	// RowPolicy - условия вида tenant_id = :claims.tenant, ограничивающие строки, доступные запросу
+	RowPolicy []string `yaml:"row_policy"`
+	// ReadOnly запрещает изменять таблицу
+	ReadOnly bool `yaml:"read_only"`
+	// SoftDelete - колонка deleted_at или is_deleted: DELETE помечает запись удалённой, а не удаляет её
+	SoftDelete string `yaml:"soft_delete"`
+	// RequireIfMatch перекрывает общую настройку require_if_match
+	RequireIfMatch *bool `yaml:"require_if_match"`
+	// CacheControl перекрывает общую настройку cache_control
+	CacheControl string `yaml:"cache_control"`
+	// CacheTTL перекрывает cache.ttl, отрицательное значение - таблицу не кешировать
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// LastModifiedColumn - колонка со временем изменения записи для Last-Modified, по умолчанию updated_at, если она есть
+	LastModifiedColumn string `yaml:"last_modified_column"`
+}
+
+// TablePaginationConfig - нулевые значения означают, что берётся общая настройка
+type TablePaginationConfig struct {
+	DefaultLimit   int   `yaml:"default_limit"`
+	MaxLimit       int   `yaml:"max_limit"`
+	AllowUnbounded *bool `yaml:"allow_unbounded"`
+}
+
+// AuditConfig - куда писать аудит изменений: таблица (в той же транзакции) и/или JSONL файл
+type AuditConfig struct {
+	Table string `yaml:"table"`
+	File  string `yaml:"file"`
+
+	file *auditFile
+}
+
+func (ac *AuditConfig) enabled() bool {
+	return ac.Table != "" || ac.File != ""
+}
+
+// open открывает файл аудита, все explorer'ы с этим конфигом пишут в него же
+func (ac *AuditConfig) open() error {
+	if ac.File == "" || ac.file != nil {
+		return nil
+	}
+	f, err := os.OpenFile(ac.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	ac.file = &auditFile{file: f}
+	return nil
+}
+
+// HistoryConfig - журнал изменений для GET /$table/$id/_history и ?as_of, схема таблицы та же, что у аудита
+type HistoryConfig struct {
+	Table string `yaml:"table"`
+	// Tables - для каких таблиц вести историю (имена или шаблоны), пустой список - для всех
+	Tables []string `yaml:"tables"`
+}
+
+func (hc HistoryConfig) enabledFor(table string) bool {
+	return hc.Table != "" && (len(hc.Tables) == 0 || matchAny(hc.Tables, table))
+}
+
+// CacheConfig - кеш чтений в памяти процесса, Size 0 - кеш выключен
+type CacheConfig struct {
+	// Size - сколько ответов (записей и страниц списков) держать, лишние вытесняются по LRU
+	Size int `yaml:"size"`
+	// TTL - сколько ответ живёт в кеше, если его раньше не сбросила запись через explorer
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// CSVConfig - настройки выгрузки списков в CSV
+type CSVConfig struct {
+	// Null - как выводить NULL, по умолчанию пустая строка. Импорт читает как NULL только непустое значение
+	Null string `yaml:"null"`
+}
+
+// ImportConfig - настройки POST /$table/_import
+type ImportConfig struct {
+	// ChunkSize - сколько строк вставлять в одной транзакции
+	ChunkSize int `yaml:"chunk_size"`
+}
+
+// DumpConfig - настройки GET /_dump и GET /$table/_dump
+type DumpConfig struct {
+	// BatchSize - сколько строк в одном INSERT
+	BatchSize int `yaml:"batch_size"`
+}
+
+type LogConfig struct {
+	// Output - stdout, stderr или путь к файлу
+	Output string `yaml:"output"`
+	Prefix string `yaml:"prefix"`
+
+	writer io.Writer
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Driver: "mysql",
+		Listen: ":8082",
+		Timeouts: TimeoutsConfig{
+			Read:  10 * time.Second,
+			Write: 30 * time.Second,
+			Idle:  time.Minute,
+		},
+		Pagination: PaginationConfig{
+			DefaultLimit: 5,
+			MaxLimit:     1000,
+		},
+		Log: LogConfig{
+			Output: "stdout",
+		},
+		Cache: CacheConfig{
+			TTL: 10 * time.Second,
+		},
+		Import: ImportConfig{
+			ChunkSize: 500,
+		},
+		Dump: DumpConfig{
+			BatchSize: 100,
+		},
+		filesMu: &sync.Mutex{},
+	}
+}
+
+const envPrefix = "DB_EXPLORER_"
+
+// configSetting - параметр, который можно задать и флагом, и переменной окружения.
+// Имя переменной получается из имени флага: max-open-conns -> DB_EXPLORER_MAX_OPEN_CONNS.
+// Пустое значение тоже значение: оно сбрасывает параметр из файла в пустую строку, 0 или false
+type configSetting struct {
+	name  string
+	usage string
+	set   func(c *Config, v string) error
+	// isBool - флаг можно передать без значения: -read-only вместо -read-only=true
+	isBool bool
+}
+
+// settingFlag запоминает значение флага как есть: применяется оно после файла и окружения
+type settingFlag struct {
+	values map[string]string
+	name   string
+	isBool bool
+}
+
+func (f *settingFlag) String() string {
+	return ""
+}
+
+func (f *settingFlag) Set(v string) error {
+	f.values[f.name] = v
+	return nil
+}
+
+// IsBoolFlag - flag.FlagSet вызывает Set("true"), если у булева флага нет значения
+func (f *settingFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+func (s configSetting) envName() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+func stringSetting(name, usage string, field func(c *Config) *string) configSetting {
+	return configSetting{name: name, usage: usage, set: func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}}
+}
+
+func intSetting(name, usage string, field func(c *Config) *int) configSetting {
+	return configSetting{name: name, usage: usage, set: func(c *Config, v string) error {
+		if v == "" {
+			*field(c) = 0
+			return nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", name, v)
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func durationSetting(name, usage string, field func(c *Config) *time.Duration) configSetting {
+	return configSetting{name: name, usage: usage, set: func(c *Config, v string) error {
+		if v == "" {
+			*field(c) = 0
+			return nil
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration", name, v)
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+func boolSetting(name, usage string, field func(c *Config) *bool) configSetting {
+	return configSetting{name: name, usage: usage, isBool: true, set: func(c *Config, v string) error {
+		if v == "" {
+			*field(c) = false
+			return nil
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", name, v)
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+func listSetting(name, usage string, field func(c *Config) *[]string) configSetting {
+	return configSetting{name: name, usage: usage, set: func(c *Config, v string) error {
+		list := make([]string, 0)
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}}
+}
+
+var configSettings = []configSetting{
+	stringSetting("driver", "database driver: mysql, postgres or sqlite3", func(c *Config) *string { return &c.Driver }),
+	stringSetting("dsn", "database connection string", func(c *Config) *string { return &c.DSN }),
+	stringSetting("listen", "address to listen on", func(c *Config) *string { return &c.Listen }),
+	stringSetting("tls-cert-file", "TLS certificate file, enables https together with tls-key-file", func(c *Config) *string { return &c.TLS.CertFile }),
+	stringSetting("tls-key-file", "TLS private key file", func(c *Config) *string { return &c.TLS.KeyFile }),
+	intSetting("max-open-conns", "max open connections to the database, 0 - unlimited", func(c *Config) *int { return &c.Pool.MaxOpenConns }),
+	intSetting("max-idle-conns", "max idle connections to the database", func(c *Config) *int { return &c.Pool.MaxIdleConns }),
+	durationSetting("conn-max-lifetime", "max lifetime of a database connection", func(c *Config) *time.Duration { return &c.Pool.ConnMaxLifetime }),
+	durationSetting("conn-max-idle-time", "max idle time of a database connection", func(c *Config) *time.Duration { return &c.Pool.ConnMaxIdleTime }),
+	durationSetting("read-timeout", "http read timeout", func(c *Config) *time.Duration { return &c.Timeouts.Read }),
+	durationSetting("write-timeout", "http write timeout", func(c *Config) *time.Duration { return &c.Timeouts.Write }),
+	durationSetting("idle-timeout", "http keep-alive idle timeout", func(c *Config) *time.Duration { return &c.Timeouts.Idle }),
+	durationSetting("query-timeout", "max time to handle one request, 0 - unlimited", func(c *Config) *time.Duration { return &c.Timeouts.Query }),
+	intSetting("default-limit", "default page size", func(c *Config) *int { return &c.Pagination.DefaultLimit }),
+	intSetting("max-limit", "max page size, 0 - unlimited", func(c *Config) *int { return &c.Pagination.MaxLimit }),
+	boolSetting("allow-unbounded", "allow limit=all to fetch whole tables", func(c *Config) *bool { return &c.Pagination.AllowUnbounded }),
+	listSetting("tables", "comma separated names or globs of exposed tables, empty - all", func(c *Config) *[]string { return &c.Tables.Include }),
+	listSetting("exclude-tables", "comma separated names or globs of hidden tables", func(c *Config) *[]string { return &c.Tables.Exclude }),
+	stringSetting("api-keys-table", "table with label and key_hash columns to read api keys from", func(c *Config) *string { return &c.Auth.APIKeysTable }),
+	stringSetting("jwt-secret", "HS256 secret to verify JWT", func(c *Config) *string { return &c.Auth.JWT.Secret }),
+	stringSetting("jwt-public-key-file", "PEM file with RSA or EC public key to verify JWT", func(c *Config) *string { return &c.Auth.JWT.PublicKeyFile }),
+	stringSetting("jwks-file", "local JWKS file with keys to verify JWT", func(c *Config) *string { return &c.Auth.JWT.JWKSFile }),
+	stringSetting("jwt-issuer", "required iss claim", func(c *Config) *string { return &c.Auth.JWT.Issuer }),
+	stringSetting("jwt-audience", "required aud claim", func(c *Config) *string { return &c.Auth.JWT.Audience }),
+	stringSetting("policy-file", "yaml file with roles and table access rules", func(c *Config) *string { return &c.Auth.PolicyFile }),
+	stringSetting("read-only-dsn", "separate connection string for GET requests", func(c *Config) *string { return &c.ReadOnlyDSN }),
+	boolSetting("read-only", "reject PUT, POST, PATCH and DELETE", func(c *Config) *bool { return &c.ReadOnly }),
+	listSetting("disabled-methods", "comma separated http methods to reject", func(c *Config) *[]string { return &c.DisabledMethods }),
+	intSetting("cache-size", "max responses in the in-process read cache, 0 - no cache", func(c *Config) *int { return &c.Cache.Size }),
+	durationSetting("cache-ttl", "how long a cached response lives", func(c *Config) *time.Duration { return &c.Cache.TTL }),
+	stringSetting("csv-null", "how NULL is written in CSV exports", func(c *Config) *string { return &c.CSV.Null }),
+	intSetting("import-chunk-size", "rows per transaction in bulk import", func(c *Config) *int { return &c.Import.ChunkSize }),
+	intSetting("dump-batch-size", "rows per INSERT statement in sql dump", func(c *Config) *int { return &c.Dump.BatchSize }),
+	stringSetting("cache-control", "Cache-Control header for record and list reads", func(c *Config) *string { return &c.CacheControl }),
+	boolSetting("require-if-match", "reject updates and deletes without If-Match header", func(c *Config) *bool { return &c.RequireIfMatch }),
+	stringSetting("audit-table", "table to write audit log to, in the same transaction as the change", func(c *Config) *string { return &c.Audit.Table }),
+	stringSetting("audit-file", "append-only JSONL file to write audit log to", func(c *Config) *string { return &c.Audit.File }),
+	stringSetting("history-table", "table to keep row history in", func(c *Config) *string { return &c.History.Table }),
+	stringSetting("log-output", "log output: stdout, stderr or file path", func(c *Config) *string { return &c.Log.Output }),
+	stringSetting("log-prefix", "log prefix", func(c *Config) *string { return &c.Log.Prefix }),
+	durationSetting("schema-poll-interval", "how often to check the database schema for changes, 0 - never", func(c *Config) *time.Duration { return &c.SchemaPollInterval }),
+}
+
+// LoadConfig собирает конфиг из файла, окружения и аргументов командной строки, проверяет его
+// и открывает файлы лога и аудита. lookupEnv - как os.LookupEnv: заданная пустой переменная перекрывает файл
+func LoadConfig(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	fs := flag.NewFlagSet("db_explorer", flag.ContinueOnError)
+	defaultPath, _ := lookupEnv(envPrefix + "CONFIG")
+	configPath := fs.String("config", defaultPath, "path to yaml or json config file")
+	flagValues := make(map[string]string)
+	for _, s := range configSettings {
+		fs.Var(&settingFlag{values: flagValues, name: s.name, isBool: s.isBool}, s.name, fmt.Sprintf("%s (env %s)", s.usage, s.envName()))
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := DefaultConfig()
+	if *configPath != "" {
+		err = cfg.loadFile(*configPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range configSettings {
+		if v, ok := lookupEnv(s.envName()); ok {
+			if err = s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("env %s", err)
+			}
+		}
+	}
+	for _, s := range configSettings {
+		if v, ok := flagValues[s.name]; ok {
+			if err = s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("flag %s", err)
+			}
+		}
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.OpenFiles()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile читает yaml, json - частный случай yaml, поэтому разбирается тем же парсером
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	err = dec.Decode(c)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate возвращает все найденные ошибки разом, чтобы их можно было исправить за один заход
+func (c *Config) Validate() error {
+	errs := make([]error, 0)
+	if len(c.Databases) == 0 {
+		if _, err := DialectByDriver(c.Driver); err != nil {
+			errs = append(errs, fmt.Errorf("driver: %w", err))
+		}
+		if c.DSN == "" {
+			errs = append(errs, errors.New("dsn is required"))
+		}
+	}
+	names := make(map[string]bool)
+	for i, d := range c.Databases {
+		switch {
+		case d.Name == "":
+			errs = append(errs, fmt.Errorf("databases[%d]: name is required", i))
+		case strings.Contains(d.Name, "/"):
+			errs = append(errs, fmt.Errorf("databases[%d]: name %q must not contain /", i, d.Name))
+		case names[d.Name]:
+			errs = append(errs, fmt.Errorf("databases[%d]: name %s declared twice", i, d.Name))
+		}
+		names[d.Name] = true
+		if _, err := DialectByDriver(d.Driver); err != nil {
+			errs = append(errs, fmt.Errorf("databases[%d]: driver: %w", i, err))
+		}
+		if d.DSN == "" {
+			errs = append(errs, fmt.Errorf("databases[%d]: dsn is required", i))
+		}
+	}
+	if c.Listen == "" {
+		errs = append(errs, errors.New("listen is required"))
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls: cert_file and key_file must be set together"))
+	}
+	for _, f := range []string{c.TLS.CertFile, c.TLS.KeyFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			errs = append(errs, fmt.Errorf("tls: %w", err))
+		}
+	}
+	if c.Pool.MaxOpenConns < 0 || c.Pool.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("pool: sizes must not be negative"))
+	}
+	if c.Pool.ConnMaxLifetime < 0 || c.Pool.ConnMaxIdleTime < 0 {
+		errs = append(errs, errors.New("pool: durations must not be negative"))
+	}
+	if c.Timeouts.Read < 0 || c.Timeouts.Write < 0 || c.Timeouts.Idle < 0 || c.Timeouts.Query < 0 {
+		errs = append(errs, errors.New("timeouts must not be negative"))
+	}
+	if c.Cache.Size < 0 {
+		errs = append(errs, errors.New("cache: size must not be negative"))
+	}
+	if c.Cache.Size > 0 && c.Cache.TTL <= 0 {
+		errs = append(errs, errors.New("cache: ttl must be positive"))
+	}
+	if c.Import.ChunkSize <= 0 {
+		errs = append(errs, errors.New("import: chunk_size must be positive"))
+	}
+	if c.Dump.BatchSize <= 0 {
+		errs = append(errs, errors.New("dump: batch_size must be positive"))
+	}
+	if c.Pagination.DefaultLimit <= 0 {
+		errs = append(errs, errors.New("pagination: default_limit must be positive"))
+	}
+	if c.Pagination.MaxLimit < 0 {
+		errs = append(errs, errors.New("pagination: max_limit must not be negative"))
+	}
+	if c.Pagination.MaxLimit > 0 && c.Pagination.DefaultLimit > c.Pagination.MaxLimit {
+		errs = append(errs, errors.New("pagination: default_limit is greater than max_limit"))
+	}
+	for _, pattern := range append(append([]string{}, c.Tables.Include...), c.Tables.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("tables: bad pattern %q", pattern))
+		}
+	}
+	errs = append(errs, c.validateTableSettings("table_settings", c.TableSettings)...)
+	for i, d := range c.Databases {
+		// пагинация таблицы базы сравнивается с общей с учётом настроек самой базы
+		errs = append(errs, c.forDatabase(d.Name).validateTableSettings(fmt.Sprintf("databases[%d].table_settings", i), d.TableSettings)...)
+	}
+	labels := make(map[string]bool)
+	for i, kc := range c.Auth.APIKeys {
+		if _, err := parseAPIKey(kc.Label, kc.Hash); err != nil {
+			errs = append(errs, fmt.Errorf("auth.api_keys[%d]: %w", i, err))
+		}
+		if labels[kc.Label] {
+			errs = append(errs, fmt.Errorf("auth.api_keys[%d]: label %s declared twice", i, kc.Label))
+		}
+		labels[kc.Label] = true
+	}
+	if c.Auth.JWT.enabled() {
+		if _, err := newJWTVerifier(c.Auth.JWT); err != nil {
+			errs = append(errs, fmt.Errorf("auth.%w", err))
+		}
+	}
+	if c.Auth.PolicyFile != "" {
+		if !c.Auth.enabled() {
+			errs = append(errs, errors.New("auth.policy_file requires api keys or jwt"))
+		}
+		if _, err := loadPolicy(c.Auth.PolicyFile); err != nil {
+			errs = append(errs, fmt.Errorf("auth.%w", err))
+		}
+	}
+	for _, m := range c.DisabledMethods {
+		switch strings.ToUpper(m) {
+		case http.MethodGet, http.MethodPut, http.MethodPost, http.MethodPatch, http.MethodDelete:
+		default:
+			errs = append(errs, fmt.Errorf("disabled_methods: unknown method %s", m))
+		}
+	}
+	if c.SchemaPollInterval < 0 {
+		errs = append(errs, errors.New("schema_poll_interval must not be negative"))
+	}
+	if len(c.History.Tables) > 0 && c.History.Table == "" {
+		errs = append(errs, errors.New("history: tables requires table"))
+	}
+	return errors.Join(errs...)
+}
+
+// validateTableSettings проверяет settings - общие table_settings или table_settings одной из баз
+func (c *Config) validateTableSettings(prefix string, settings map[string]TableConfig) []error {
+	errs := make([]error, 0)
+	for name, tc := range settings {
+		for _, col := range tc.ReadOnlyColumns {
+			if containsString(tc.WriteOnlyColumns, col) {
+				errs = append(errs, fmt.Errorf("%s.%s: column %s is both read only and write only", prefix, name, col))
+			}
+		}
+		if _, err := parseRowPolicy(tc.RowPolicy); err != nil {
+			errs = append(errs, fmt.Errorf("%s.%s.row_policy: %w", prefix, name, err))
+		}
+		if tc.LastModifiedColumn != "" && !isIdent(tc.LastModifiedColumn) {
+			errs = append(errs, fmt.Errorf("%s.%s.last_modified_column: bad column name %q", prefix, name, tc.LastModifiedColumn))
+		}
+		if tc.SoftDelete != "" && !isIdent(tc.SoftDelete) {
+			errs = append(errs, fmt.Errorf("%s.%s.soft_delete: bad column name %q", prefix, name, tc.SoftDelete))
+		}
+		if len(tc.RowPolicy) > 0 && !c.Auth.JWT.enabled() {
+			errs = append(errs, fmt.Errorf("%s.%s.row_policy: requires jwt", prefix, name))
+		}
+		if tc.Pagination.DefaultLimit < 0 || tc.Pagination.MaxLimit < 0 {
+			errs = append(errs, fmt.Errorf("%s.%s.pagination: limits must not be negative", prefix, name))
+		}
+		page := c.pagination(name)
+		if page.MaxLimit > 0 && page.DefaultLimit > page.MaxLimit {
+			errs = append(errs, fmt.Errorf("%s.%s.pagination: default_limit is greater than max_limit", prefix, name))
+		}
+	}
+	return errs
+}
+
+// OpenFiles открывает файл лога и файл аудита. Validate файлов не трогает, открывает их LoadConfig,
+// а для конфига, собранного в коде, - конструктор explorer'а. Повторный вызов ничего не делает
+func (c *Config) OpenFiles() error {
+	c.filesMu.Lock()
+	defer c.filesMu.Unlock()
+	if err := c.Log.open(); err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+	if err := c.Audit.open(); err != nil {
+		return fmt.Errorf("audit: %w", err)
+	}
+	return nil
+}
+
+// open открывает файл лога, все explorer'ы с этим конфигом пишут в него же
+func (lc *LogConfig) open() error {
+	if lc.writer != nil {
+		return nil
+	}
+	switch lc.Output {
+	case "", "stdout":
+		lc.writer = os.Stdout
+	case "stderr":
+		lc.writer = os.Stderr
+	default:
+		f, err := os.OpenFile(lc.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		lc.writer = f
+	}
+	return nil
+}
+
+// Logger пишет в открытый OpenFiles вывод, до открытия - в stdout
+func (lc *LogConfig) Logger() *log.Logger {
+	w := lc.writer
+	if w == nil {
+		w = os.Stdout
+	}
+	return log.New(w, lc.Prefix, log.Lshortfile)
+}
+
+// pagination возвращает настройки пагинации таблицы с учётом общих
+func (c *Config) pagination(table string) PaginationConfig {
+	page := c.Pagination
+	tc, ok := c.TableSettings[table]
+	if !ok {
+		return page
+	}
+	if tc.Pagination.DefaultLimit > 0 {
+		page.DefaultLimit = tc.Pagination.DefaultLimit
+	}
+	if tc.Pagination.MaxLimit > 0 {
+		page.MaxLimit = tc.Pagination.MaxLimit
+	}
+	if tc.Pagination.AllowUnbounded != nil {
+		page.AllowUnbounded = *tc.Pagination.AllowUnbounded
+	}
+	return page
+}
+
+func (c *Config) requireIfMatch(table string) bool {
+	if tc, ok := c.TableSettings[table]; ok && tc.RequireIfMatch != nil {
+		return *tc.RequireIfMatch
+	}
+	return c.RequireIfMatch
+}
+
+func (c *Config) cacheControl(table string) string {
+	if tc, ok := c.TableSettings[table]; ok && tc.CacheControl != "" {
+		return tc.CacheControl
+	}
+	return c.CacheControl
+}
+
+func (c *Config) cacheTTL(table string) time.Duration {
+	if tc, ok := c.TableSettings[table]; ok && tc.CacheTTL != 0 {
+		return tc.CacheTTL
+	}
+	return c.Cache.TTL
+}
+
+func (c *Config) lastModifiedColumn(table string) string {
+	if tc, ok := c.TableSettings[table]; ok && tc.LastModifiedColumn != "" {
+		return tc.LastModifiedColumn
+	}
+	return "updated_at"
+}
+
+// isTableExposed проверяет имя таблицы по include/exclude
+func (c *Config) isTableExposed(name string) bool {
+	if len(c.Tables.Include) > 0 && !matchAny(c.Tables.Include, name) {
+		return false
+	}
+	return !matchAny(c.Tables.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lookupIn - окружение из map для LoadConfig
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+dsn: "from-file"
+listen: ":9000"
+pagination:
+  default_limit: 10
+  max_limit: 100
+timeouts:
+  query: 5s
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"DB_EXPLORER_CONFIG":    path,
+		"DB_EXPLORER_LISTEN":    ":9001",
+		"DB_EXPLORER_MAX_LIMIT": "50",
+		"DB_EXPLORER_TABLES":    "items, users",
+	}
+
+	cfg, err := LoadConfig([]string{"-listen", ":9002"}, lookupIn(env))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// файл перекрывает значения по умолчанию, окружение - файл, флаги - окружение
+	if cfg.Driver != "mysql" || cfg.DSN != "from-file" || cfg.Listen != ":9002" {
+		t.Errorf("bad connection settings: %+v", cfg)
+	}
+	if cfg.Pagination.DefaultLimit != 10 || cfg.Pagination.MaxLimit != 50 {
+		t.Errorf("bad pagination: %+v", cfg.Pagination)
+	}
+	if cfg.Timeouts.Query != 5*time.Second {
+		t.Errorf("bad query timeout: %v", cfg.Timeouts.Query)
+	}
+	if strings.Join(cfg.Tables.Include, ",") != "items,users" {
+		t.Errorf("bad tables: %v", cfg.Tables)
+	}
+
+	cfg.Databases = []DatabaseConfig{{Name: "reports", Driver: "sqlite3", DSN: "x", TableSettings: map[string]TableConfig{
+		"items": {Pagination: TablePaginationConfig{DefaultLimit: 500}},
+	}}}
+	if err = cfg.Validate(); err == nil || !strings.Contains(err.Error(), "databases[0].table_settings.items.pagination: default_limit is greater than max_limit") {
+		t.Errorf("table_settings of database must be validated, got %v", err)
+	}
+
+	_, err = LoadConfig([]string{"-driver", "oracle", "-default-limit", "0"}, lookupIn(nil))
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"unsupported driver", "dsn is required", "default_limit must be positive"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	err := os.WriteFile(path, []byte(`
+dsn: "from-file"
+csv:
+  null: NULL
+require_if_match: true
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"DB_EXPLORER_CONFIG": path,
+		// пустая переменная перекрывает значение из файла
+		"DB_EXPLORER_CSV_NULL":         "",
+		"DB_EXPLORER_REQUIRE_IF_MATCH": "",
+	}
+	auditPath := filepath.Join(dir, "audit.jsonl")
+	// булевы флаги передаются без значения, но и -flag=false работает
+	cfg, err := LoadConfig([]string{"-read-only", "-allow-unbounded=false", "-audit-file", auditPath}, lookupIn(env))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.ReadOnly || cfg.Pagination.AllowUnbounded {
+		t.Errorf("bad bool flags: read_only=%v allow_unbounded=%v", cfg.ReadOnly, cfg.Pagination.AllowUnbounded)
+	}
+	if cfg.CSV.Null != "" || cfg.RequireIfMatch {
+		t.Errorf("empty env must override file: csv.null=%q require_if_match=%v", cfg.CSV.Null, cfg.RequireIfMatch)
+	}
+	// файлы открывает LoadConfig, а не Validate
+	if cfg.Audit.file == nil || cfg.Log.writer == nil {
+		t.Error("audit and log files must be opened by LoadConfig")
+	}
+
+	cfg = DefaultConfig()
+	cfg.DSN = "x"
+	cfg.Audit.File = filepath.Join(dir, "no-such-dir", "audit.jsonl")
+	if err = cfg.Validate(); err != nil {
+		t.Errorf("validate must not open files: %v", err)
+	}
+	if cfg.Audit.file != nil {
+		t.Error("validate must not open audit file")
+	}
+	if err = cfg.OpenFiles(); err == nil || !strings.HasPrefix(err.Error(), "audit:") {
+		t.Errorf("expected audit open error, got %v", err)
+	}
+}
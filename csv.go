@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Выгрузка списка в CSV по RFC 4180: первая строка - имена колонок в порядке TableInfo.Fields,
+// строки разделяются CRLF, поля с запятыми, кавычками и переводами строк берутся в кавычки.
+// NULL выводится как csv.null из настроек (по умолчанию пустая строка) или как ?null=... из запроса
+
+// csvColumns - колонки, которые попадают в выгрузку: те же, что отдаёт convertRow
+func csvColumns(tableInfo *TableInfo) []string {
+	columns := make([]string, 0, len(tableInfo.Fields))
+	for _, fldInfo := range tableInfo.Fields {
+		if !fldInfo.WriteOnly {
+			columns = append(columns, fldInfo.Field)
+		}
+	}
+	return columns
+}
+
+func csvValue(v interface{}, null string) string {
+	switch val := v.(type) {
+	case nil:
+		return null
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+func writeCSV(w io.Writer, columns []string, rows []map[string]interface{}, null string) error {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	err := cw.Write(columns)
+	if err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, col := range columns {
+			record[i] = csvValue(row[col], null)
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// sendCSV отдаёт страницу списка файлом. Выгрузка готовится целиком, чтобы посчитать ETag по готовому телу
+func (e *DbExplorer) sendCSV(w http.ResponseWriter, r *http.Request, tableInfo *TableInfo, rows []map[string]interface{}) {
+	null := e.Config.CSV.Null
+	if r.URL.Query().Has("null") {
+		null = r.URL.Query().Get("null")
+	}
+	buf := &bytes.Buffer{}
+	err := writeCSV(buf, csvColumns(tableInfo), rows, null)
+	if err != nil {
+		e.logRequest(r, err)
+		sendErrResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if e.writeCacheHeaders(w, r, tableInfo.TableName, bytesETag(buf.Bytes()), e.lastModified(tableInfo, rows...)) {
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": tableInfo.TableName + ".csv"}))
+	w.Write(buf.Bytes())
+}
@@ -1,138 +1,152 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
 )
 
+// fieldError - ошибка валидации значения поля, на неё отвечаем 400
+type fieldError struct {
+	Field string
+	Err   error
+}
+
+func (fe *fieldError) Error() string {
+	return fmt.Sprintf("field %s %s", fe.Field, fe.Err)
+}
+
+func (fe *fieldError) Unwrap() error {
+	return fe.Err
+}
+
 func convertRow(columnPointers []interface{}, tableInfo *TableInfo) map[string]interface{} {
 	convertedRow := make(map[string]interface{})
 	for i, fldInfo := range tableInfo.Fields {
-		val := *columnPointers[i].(*interface{})
-		switch data := val.(type) {
-		case []byte:
-			switch {
-			case strings.Contains(fldInfo.Type, "char") || strings.Contains(fldInfo.Type, "text"):
-				convertedRow[fldInfo.Field] = string(data)
-				continue
-			case strings.Contains(fldInfo.Type, "int"):
-				strVal := string(data)
-				intVal, _ := strconv.ParseInt(strVal, 10, 64)
-				convertedRow[fldInfo.Field] = intVal
-				continue
-			case strings.Contains(fldInfo.Type, "double") || strings.Contains(fldInfo.Type, "decimal") || strings.Contains(fldInfo.Type, "float"):
-				strVal := string(data)
-				fltVal, _ := strconv.ParseFloat(strVal, 64)
-				convertedRow[fldInfo.Field] = fltVal
-				continue
-			}
-		case int64:
-			convertedRow[fldInfo.Field] = data
-		case nil:
-			convertedRow[fldInfo.Field] = data
+		if fldInfo.WriteOnly {
+			continue
 		}
+		val := *columnPointers[i].(*interface{})
+		convertedRow[fldInfo.Field] = fldInfo.columnType().decode(val)
 	}
 
 	return convertedRow
 }
 
-func (e *DbExplorer) getAllRowsFromTable(tableName string) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf("SELECT * FROM %s", tableName)
-	rows, err := e.Db.Query(query)
+// selectColumns - список полей таблицы для SELECT в том же порядке, что и tableInfo.Fields, его ожидает convertRow
+func (e *DbExplorer) selectColumns(tableInfo *TableInfo) string {
+	columns := make([]string, 0, len(tableInfo.Fields))
+	for _, fldInfo := range tableInfo.Fields {
+		columns = append(columns, e.Dialect.QuoteIdent(fldInfo.Field))
+	}
+	return strings.Join(columns, ", ")
+}
+
+func (e *DbExplorer) queryRows(ctx context.Context, tableInfo *TableInfo, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	results := make([]map[string]interface{}, 0)
+	err := e.eachRow(ctx, tableInfo, query, args, func(row map[string]interface{}) error {
+		results = append(results, row)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return results, nil
+}
+
+// eachRow передаёт в fn записи по мере чтения, не держа в памяти весь результат. Ошибка fn прерывает чтение
+func (e *DbExplorer) eachRow(ctx context.Context, tableInfo *TableInfo, query string, args []interface{}, fn func(row map[string]interface{}) error) error {
+	rows, err := e.reader(ctx).QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
 	defer rows.Close()
-	resultRows := make([]map[string]interface{}, 0)
-	tableInfo := e.TablesInfo[tableName]
 	colsCount := len(tableInfo.Fields)
 	for rows.Next() {
 		columns := make([]interface{}, colsCount)
 		colPointers := make([]interface{}, colsCount)
-		for i := range columns {
+		for i := range colPointers {
 			colPointers[i] = &columns[i]
 		}
 		err := rows.Scan(colPointers...)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		err = fn(convertRow(colPointers, tableInfo))
+		if err != nil {
+			return err
 		}
-		convertedRow := convertRow(colPointers, tableInfo)
-		resultRows = append(resultRows, convertedRow)
 	}
-	return resultRows, nil
+	return rows.Err()
 }
 
-func (e *DbExplorer) getRowsFromTableByLimit(tableName string, limit int) ([]map[string]interface{}, error) {
-	query := fmt.Sprintf("SELECT * FROM %s LIMIT ?", tableName)
-	tableInfo := e.TablesInfo[tableName]
-	colsCount := len(tableInfo.Fields)
-	rows, err := e.Db.Query(query, limit)
+// listQuery - запрос страницы списка, limit -1 - вся таблица
+func (e *DbExplorer) listQuery(ctx context.Context, tableInfo *TableInfo, limit int64, offset int64) (string, []interface{}, error) {
+	conds, err := e.rowConditions(ctx, tableInfo)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
-	defer rows.Close()
-	results := make([]map[string]interface{}, 0)
-	for rows.Next() {
-		columns := make([]interface{}, colsCount)
-		colPointers := make([]interface{}, colsCount)
-		for i := range colPointers {
-			colPointers[i] = &columns[i]
-		}
-		err := rows.Scan(colPointers...)
-		if err != nil {
-			return nil, err
-		}
-		convertedRow := convertRow(colPointers, tableInfo)
-		results = append(results, convertedRow)
+	args := sqlArgs{dialect: e.Dialect}
+	query := fmt.Sprintf("SELECT %s FROM %s", e.selectColumns(tableInfo), e.Dialect.QuoteIdent(tableInfo.TableName))
+	query += e.rowWhere(conds, &args, "WHERE")
+	query += e.softDeleteWhere(ctx, tableInfo, &args, whereOrAnd(conds))
+	if limit < 0 {
+		return query, args.values, nil
+	}
+	if pkName := tableInfo.findPrimKeyName(); pkName != nil {
+		query += " ORDER BY " + e.Dialect.QuoteIdent(*pkName)
 	}
+	query += e.Dialect.LimitOffset(args.add(limit), args.add(offset))
+	return query, args.values, nil
+}
 
-	return results, nil
+func (e *DbExplorer) getAllRowsFromTable(ctx context.Context, tableInfo *TableInfo) ([]map[string]interface{}, error) {
+	return e.getRowsFromTableByLimitAndOffset(ctx, tableInfo, -1, 0)
 }
 
-func (e *DbExplorer) getRowsFromTableByLimitAndOffset(tableName string, limit int64, offset int64) ([]map[string]interface{}, error) {
-	tableInfo := e.TablesInfo[tableName]
-	pkName := tableInfo.findPrimKeyName()
-	query := fmt.Sprintf("SELECT * FROM %s WHERE %s > ? LIMIT ?", tableName, *pkName)
-	rows, err := e.Db.Query(query, offset, limit)
+func (e *DbExplorer) getRowsFromTableByLimitAndOffset(ctx context.Context, tableInfo *TableInfo, limit int64, offset int64) ([]map[string]interface{}, error) {
+	query, args, err := e.listQuery(ctx, tableInfo, limit, offset)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return e.queryRows(ctx, tableInfo, query, args...)
+}
 
-	colsCount := len(tableInfo.Fields)
-	results := make([]map[string]interface{}, 0)
-	for rows.Next() {
-		columns := make([]interface{}, colsCount)
-		colPointers := make([]interface{}, colsCount)
-		for i := range colPointers {
-			colPointers[i] = &columns[i]
-		}
-		err := rows.Scan(colPointers...)
-		if err != nil {
-			return nil, err
-		}
-		convertedRow := convertRow(colPointers, tableInfo)
-		results = append(results, convertedRow)
+func (e *DbExplorer) getRowFromTableById(ctx context.Context, tableInfo *TableInfo, id int64) (map[string]interface{}, error) {
+	return e.queryRowById(ctx, e.reader(ctx), tableInfo, id)
+}
+
+// whereOrAnd - чем начинать следующее условие после условий построчной политики
+func whereOrAnd(conds []rowCondition) string {
+	if len(conds) == 0 {
+		return "WHERE"
 	}
-	return results, nil
+	return "AND"
 }
 
-func (e *DbExplorer) getRowFromTableById(tableName string, id int64) (map[string]interface{}, error) {
-	tableInfo := e.TablesInfo[tableName]
+// queryRowById - то же, что getRowFromTableById, но внутри переданной транзакции
+func (e *DbExplorer) queryRowById(ctx context.Context, ex dbExecutor, tableInfo *TableInfo, id int64) (map[string]interface{}, error) {
 	colsCount := len(tableInfo.Fields)
 	primKeyFieldName := tableInfo.findPrimKeyName()
+	conds, err := e.rowConditions(ctx, tableInfo)
+	if err != nil {
+		return nil, err
+	}
 
-	query := fmt.Sprintf("SELECT * FROM %s WHERE %s = ?", tableName, *primKeyFieldName)
+	args := sqlArgs{dialect: e.Dialect}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s",
+		e.selectColumns(tableInfo), e.Dialect.QuoteIdent(tableInfo.TableName), e.Dialect.QuoteIdent(*primKeyFieldName), args.add(id))
+	query += e.rowWhere(conds, &args, "AND")
+	query += e.softDeleteWhere(ctx, tableInfo, &args, "AND")
 	columns := make([]interface{}, colsCount)
 	columnPointers := make([]interface{}, colsCount)
 	for i := range columnPointers {
 		columnPointers[i] = &columns[i]
 	}
-	err := e.Db.QueryRow(query, id).Scan(columnPointers...)
+	err = ex.QueryRowContext(ctx, query, args.values...).Scan(columnPointers...)
 	if err != nil {
 		return nil, err
 	}
@@ -141,7 +155,25 @@ func (e *DbExplorer) getRowFromTableById(tableName string, id int64) (map[string
 	return result, nil
 }
 
-func (e *DbExplorer) addRowToTable(tableName string, record map[string]interface{}) (*int64, error) {
+func (e *DbExplorer) addRowToTable(ctx context.Context, tableInfo *TableInfo, record map[string]interface{}) (*int64, error) {
+	query, args, err := e.buildInsert(ctx, tableInfo, record)
+	if err != nil {
+		return nil, err
+	}
+	var lastId int64
+	err = e.inTx(ctx, func(tx *writeTx) error {
+		lastId, err = e.insertRow(ctx, tx, tableInfo, query, args)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &lastId, nil
+}
+
+// buildInsert проверяет запись по правилам таблицы и собирает INSERT для неё
+func (e *DbExplorer) buildInsert(ctx context.Context, tableInfo *TableInfo, record map[string]interface{}) (string, []interface{}, error) {
 	/*
 		1. создаем пустую мапу на основе информации о полях таблицы
 		2. идем по ключам созданной мапы, смотрим, есть ли в пришедшей мапе значения по ключам в созданной мапе
@@ -149,78 +181,101 @@ func (e *DbExplorer) addRowToTable(tableName string, record map[string]interface
 		4. если значения нет, то проверяем есть ли в таблице значение по умолчанию для данного поля, если значения по умолчанию нет - то нужно дать значение по умолчанию для данного типа
 	*/
 
-	tableInfo := e.TablesInfo[tableName]
-	rowForAdding := make(map[string]interface{})
+	conds, err := e.rowConditions(ctx, tableInfo)
+	if err != nil {
+		return "", nil, err
+	}
+	args := sqlArgs{dialect: e.Dialect}
+	columns := make([]string, 0)
+	placeholders := make([]string, 0)
 	for _, fldInfo := range tableInfo.Fields {
 		if fldInfo.Key == "PRI" {
 			continue
 		}
-		switch {
-		case strings.Contains(fldInfo.Type, "char") || strings.Contains(fldInfo.Type, "text"):
-			if fldInfo.Null == "NO" {
-				rowForAdding[fldInfo.Field] = ""
-			} else {
-				rowForAdding[fldInfo.Field] = nil
+		ct := fldInfo.columnType()
+		v, exists := record[fldInfo.Field]
+		if cond, ok := findRowCondition(conds, fldInfo.Field); ok {
+			// колонку политики заполняем сами, чужое значение передать нельзя
+			if exists && !sameRowValue(v, cond.Value) {
+				return "", nil, &fieldError{Field: fldInfo.Field, Err: errRowPolicy}
 			}
-			v, exists := record[fldInfo.Field]
+			columns = append(columns, e.Dialect.QuoteIdent(fldInfo.Field))
+			placeholders = append(placeholders, args.add(cond.Value))
+			continue
+		}
+		if sd := tableInfo.SoftDelete; sd != nil && sd.Column == fldInfo.Field {
+			// новая запись всегда живая
 			if exists {
-				if val, ok := v.(string); ok {
-					rowForAdding[fldInfo.Field] = val
-				}
-			}
-		case strings.Contains(fldInfo.Type, "int"):
-			if fldInfo.Null == "NO" {
-				rowForAdding[fldInfo.Field] = 0
-			} else {
-				rowForAdding[fldInfo.Field] = nil
+				return "", nil, &fieldError{Field: fldInfo.Field, Err: errReadOnly}
 			}
-			v, exists := record[fldInfo.Field]
+			columns = append(columns, e.Dialect.QuoteIdent(fldInfo.Field))
+			placeholders = append(placeholders, args.add(sd.liveValue()))
+			continue
+		}
+		if fldInfo.ReadOnly {
+			// read only поле заполняет сама база
 			if exists {
-				if val, ok := v.(int); ok {
-					rowForAdding[fldInfo.Field] = val
-				}
-			}
-		case strings.Contains(fldInfo.Type, "double") || strings.Contains(fldInfo.Type, "float") || strings.Contains(fldInfo.Type, "decimal"):
-			if fldInfo.Null == "NO" {
-				rowForAdding[fldInfo.Type] = 0.0
-			} else {
-				rowForAdding[fldInfo.Type] = nil
+				return "", nil, &fieldError{Field: fldInfo.Field, Err: errReadOnly}
 			}
-			v, exists := record[fldInfo.Field]
-			if exists {
-				if val, ok := v.(float32); ok {
-					rowForAdding[fldInfo.Field] = val
-				}
-				if val, ok := v.(float64); ok {
-					rowForAdding[fldInfo.Field] = val
-				}
+			continue
+		}
+		if !exists {
+			v = ct.zeroValue()
+		} else {
+			val, err := ct.normalize(v)
+			if err != nil {
+				return "", nil, &fieldError{Field: fldInfo.Field, Err: err}
 			}
+			v = val
 		}
+		columns = append(columns, e.Dialect.QuoteIdent(fldInfo.Field))
+		placeholders = append(placeholders, args.add(v))
 	}
 
-	columns := make([]string, 0)
-	values := make([]interface{}, 0)
-	placeholders := make([]string, 0)
-	for k, v := range rowForAdding {
-		columns = append(columns, k)
-		values = append(values, v)
-		placeholders = append(placeholders, "?")
-	}
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", tableName, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
-	result, err := e.Db.Exec(query, values...)
-	if err != nil {
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		e.Dialect.QuoteIdent(tableInfo.TableName), strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	return query, args.values, nil
+}
 
-		return nil, err
+// insertRow выполняет INSERT из buildInsert внутри транзакции и возвращает первичный ключ новой записи
+func (e *DbExplorer) insertRow(ctx context.Context, tx *writeTx, tableInfo *TableInfo, query string, args []interface{}) (int64, error) {
+	pkName := tableInfo.findPrimKeyName()
+	lastId, err := e.Dialect.Insert(ctx, tx, query, *pkName, args)
+	if err != nil {
+		return 0, err
 	}
-	lastId, _ := result.LastInsertId()
+	return lastId, e.trackChange(ctx, tx, tableInfo, opCreate, lastId, nil)
+}
 
-	return &lastId, nil
+func (e *DbExplorer) updateRecordTable(ctx context.Context, tableInfo *TableInfo, id int64, inRecord map[string]interface{}) *Response {
+	var resp *Response
+	err := e.inTx(ctx, func(tx *writeTx) error {
+		resp = e.updateRecordInTx(ctx, tx, tableInfo, id, inRecord)
+		if resp.Err != nil {
+			return resp.Err
+		}
+		return nil
+	})
+	if err != nil && (resp == nil || resp.Err == nil) {
+		// сама запись прошла, а упал аудит или коммит
+		return &Response{
+			Err:        err,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+	return resp
 }
 
-func (e *DbExplorer) updateRecordTable(tableName string, id int64, inRecord map[string]interface{}) *Response {
+func (e *DbExplorer) updateRecordInTx(ctx context.Context, tx *writeTx, tableInfo *TableInfo, id int64, inRecord map[string]interface{}) *Response {
 
-	tableInfo := e.TablesInfo[tableName]
-	row, err := e.getRowFromTableById(tableName, id)
+	if err := e.checkIfMatch(ctx, tx, tableInfo, id); err != nil {
+		resp := Response{Err: err, StatusCode: http.StatusInternalServerError}
+		if errors.Is(err, errPreconditionFailed) {
+			resp.StatusCode = http.StatusPreconditionFailed
+		}
+		return &resp
+	}
+	before, err := e.queryRowById(ctx, tx, tableInfo, id)
 	if err != nil {
 		resp := Response{}
 		if errors.Is(err, sql.ErrNoRows) {
@@ -232,74 +287,65 @@ func (e *DbExplorer) updateRecordTable(tableName string, id int64, inRecord map[
 		}
 		return &resp
 	}
-	updRow := make(map[string]interface{})
-	for fldName := range inRecord {
-		_, exists := row[fldName]
-		if exists {
-			fldInfo := tableInfo.getFieldInfoByName(fldName)
-			if fldInfo.Key == "PRI" {
+	conds, err := e.rowConditions(ctx, tableInfo)
+	if err != nil {
+		return &Response{
+			Err:        err,
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+	args := sqlArgs{dialect: e.Dialect}
+	columns := make([]string, 0)
+	for _, fldInfo := range tableInfo.Fields {
+		v, exists := inRecord[fldInfo.Field]
+		if !exists {
+			continue
+		}
+		if cond, ok := findRowCondition(conds, fldInfo.Field); ok {
+			// перенести строку к другому владельцу нельзя, то же значение просто пропускаем
+			if !sameRowValue(v, cond.Value) {
 				return &Response{
-					Err:        fmt.Errorf("field %s have invalid type", fldName),
+					Err:        &fieldError{Field: fldInfo.Field, Err: errRowPolicy},
 					StatusCode: http.StatusBadRequest,
 				}
 			}
-			switch inRecord[fldName].(type) {
-			case string:
-				if strings.Contains(fldInfo.Type, "char") || strings.Contains(fldInfo.Type, "text") {
-					updRow[fldName] = inRecord[fldName]
-				} else {
-					return &Response{
-						Err:        fmt.Errorf("field %s have invalid type", fldName),
-						StatusCode: http.StatusBadRequest,
-					}
-				}
-			case int:
-				if strings.Contains(fldInfo.Type, "int") {
-					updRow[fldName] = inRecord[fldName]
-				} else {
-					return &Response{
-						Err:        fmt.Errorf("field %s have invalid type", fldName),
-						StatusCode: http.StatusBadRequest,
-					}
-				}
-			case float32:
-				if strings.Contains(fldInfo.Type, "double") || strings.Contains(fldInfo.Type, "decimal") || strings.Contains(fldInfo.Type, "float") {
-					updRow[fldName] = inRecord[fldName]
-				} else {
-					return &Response{
-						Err:        fmt.Errorf("field %s have invalid type", fldName),
-						StatusCode: http.StatusBadRequest,
-					}
-				}
-			case float64:
-				if strings.Contains(fldInfo.Type, "double") || strings.Contains(fldInfo.Type, "decimal") || strings.Contains(fldInfo.Type, "float") {
-					updRow[fldName] = inRecord[fldName]
-				} else {
-					return &Response{
-						Err:        fmt.Errorf("field %s have invalid type", fldName),
-						StatusCode: http.StatusBadRequest,
-					}
-				}
-			case nil:
-				if fldInfo.Null == "YES" {
-					updRow[fldName] = nil
-				} else {
-					return &Response{
-						Err:        fmt.Errorf("field %s have invalid type", fldName),
-						StatusCode: http.StatusBadRequest,
-					}
-				}
+			continue
+		}
+		if fldInfo.Key == "PRI" {
+			return &Response{
+				Err:        &fieldError{Field: fldInfo.Field, Err: errInvalidType},
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+		if fldInfo.ReadOnly {
+			return &Response{
+				Err:        &fieldError{Field: fldInfo.Field, Err: errReadOnly},
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+		val, err := fldInfo.columnType().normalize(v)
+		if err != nil {
+			return &Response{
+				Err:        &fieldError{Field: fldInfo.Field, Err: err},
+				StatusCode: http.StatusBadRequest,
 			}
 		}
+		columns = append(columns, fmt.Sprintf("%s = %s", e.Dialect.QuoteIdent(fldInfo.Field), args.add(val)))
 	}
-	columns := make([]string, 0)
-	values := make([]interface{}, 0)
-	for col, val := range updRow {
-		columns = append(columns, fmt.Sprintf("%s = ?", col))
-		values = append(values, val)
+	if len(columns) == 0 {
+		return &Response{
+			Err:        nil,
+			StatusCode: http.StatusOK,
+		}
+	}
+	pkName := tableInfo.findPrimKeyName()
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s",
+		e.Dialect.QuoteIdent(tableInfo.TableName), strings.Join(columns, ", "), e.Dialect.QuoteIdent(*pkName), args.add(id))
+	query += e.rowWhere(conds, &args, "AND")
+	_, err = tx.ExecContext(ctx, query, args.values...)
+	if err == nil {
+		err = e.trackChange(ctx, tx, tableInfo, opUpdate, id, before)
 	}
-	query := fmt.Sprintf("UPDATE %s SET %s", tableName, strings.Join(columns, ", "))
-	_, err = e.Db.Exec(query, values...)
 	if err != nil {
 		return &Response{
 			Err:        err,
@@ -313,12 +359,80 @@ func (e *DbExplorer) updateRecordTable(tableName string, id int64, inRecord map[
 	}
 }
 
-func (e *DbExplorer) deleteRecordById(tableName string, id int64) (*int64, error) {
-	query := fmt.Sprintf("DELETE FROM %s WHERE id = ?", tableName)
-	res, err := e.Db.Exec(query, id)
+func (e *DbExplorer) deleteRecordById(ctx context.Context, tableInfo *TableInfo, id int64) (*int64, error) {
+	conds, err := e.rowConditions(ctx, tableInfo)
+	if err != nil {
+		return nil, err
+	}
+	args := sqlArgs{dialect: e.Dialect}
+	pkName := tableInfo.findPrimKeyName()
+	query := fmt.Sprintf("DELETE FROM %s WHERE %s = %s",
+		e.Dialect.QuoteIdent(tableInfo.TableName), e.Dialect.QuoteIdent(*pkName), args.add(id))
+	query += e.rowWhere(conds, &args, "AND")
+	var affected int64
+	err = e.inTx(ctx, func(tx *writeTx) error {
+		if err := e.checkIfMatch(ctx, tx, tableInfo, id); err != nil {
+			return err
+		}
+		var before map[string]interface{}
+		if e.tracksChanges(tableInfo.TableName) {
+			before, err = e.queryRowById(ctx, tx, tableInfo, id)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if tableInfo.SoftDelete != nil {
+			affected, err = e.setDeleted(ctx, tx, tableInfo, id, true)
+		} else {
+			var res sql.Result
+			res, err = tx.ExecContext(ctx, query, args.values...)
+			if err == nil {
+				affected, _ = res.RowsAffected()
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return nil
+		}
+		return e.trackChange(ctx, tx, tableInfo, opDelete, id, before)
+	})
 	if err != nil {
 		return nil, err
 	}
-	affected, _ := res.RowsAffected()
 	return &affected, nil
 }
+
+// inTx выполняет fn в транзакции: изменение записи и его след в аудите сохраняются вместе или не сохраняются вовсе.
+// Изменения, которые пишутся не в базу (файл аудита), отправляются только после коммита
+func (e *DbExplorer) inTx(ctx context.Context, fn func(tx *writeTx) error) error {
+	sqlTx, err := e.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	tx := &writeTx{Tx: sqlTx}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	e.invalidateCache(tx.touched)
+	e.afterCommit(tx.changes)
+	return nil
+}
+
+// writeTx - транзакция вместе с изменениями, которые в ней сделаны
+type writeTx struct {
+	*sql.Tx
+	changes []*rowChange
+	// touched - изменённые записи, после коммита они сбрасываются из кеша чтений
+	touched []changedRow
+}
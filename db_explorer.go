@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // тут вы пишете код
@@ -25,11 +28,21 @@ type FieldInfo struct {
 	Extra      string
 	Privileges string
 	Comment    string
+
+	// ReadOnly и WriteOnly берутся не из базы, а из настроек таблицы
+	ReadOnly  bool
+	WriteOnly bool
 }
 
 type TableInfo struct {
 	TableName string
 	Fields    []*FieldInfo
+	// RowPolicy - условия из table_settings.row_policy, см. row_policy.go
+	RowPolicy []rowPredicate
+	// SoftDelete - колонка мягкого удаления из table_settings.soft_delete, см. soft_delete.go
+	SoftDelete *softDelete
+	// HasHiddenColumns - часть колонок убрана из Fields настройкой table_settings.hidden_columns
+	HasHiddenColumns bool
 }
 
 func (ti *TableInfo) getFieldInfoByName(name string) *FieldInfo {
@@ -50,61 +63,22 @@ func (ti *TableInfo) findPrimKeyName() *string {
 	return nil
 }
 
-func GetTableNames(db *sql.DB) ([]string, error) {
-	sqlQuery := "SHOW TABLES"
-	rows, err := db.Query(sqlQuery)
-	if err != nil {
-		return nil, err
-	}
-
-	defer rows.Close()
-
-	tableNames := make([]string, 0)
-	for rows.Next() {
-		var name string
-		err = rows.Scan(&name)
-		if err != nil {
-			return nil, err
-		}
-		tableNames = append(tableNames, name)
-	}
-
-	return tableNames, nil
+func GetTableNames(db *sql.DB, dialect Dialect) ([]string, error) {
+	return dialect.TableNames(db)
 }
 
-func ScanTables(db *sql.DB) (map[string]*TableInfo, error) {
-	tableNames, err := GetTableNames(db)
+func ScanTables(db *sql.DB, dialect Dialect) (map[string]*TableInfo, error) {
+	tableNames, err := GetTableNames(db, dialect)
 	if err != nil {
 		return nil, err
 	}
 
 	tablesInfo := make(map[string]*TableInfo)
 	for _, name := range tableNames {
-		tableInfoQuery := fmt.Sprintf("SHOW FULL COLUMNS FROM %s", name)
-		rows, err := db.Query(tableInfoQuery)
+		fieldsInfo, err := dialect.Columns(db, name)
 		if err != nil {
 			return nil, err
 		}
-		defer rows.Close()
-		fieldsInfo := make([]*FieldInfo, 0)
-		for rows.Next() {
-			fldInfo := FieldInfo{}
-			err = rows.Scan(
-				&fldInfo.Field,
-				&fldInfo.Type,
-				&fldInfo.Collation,
-				&fldInfo.Null,
-				&fldInfo.Key,
-				&fldInfo.Default,
-				&fldInfo.Extra,
-				&fldInfo.Privileges,
-				&fldInfo.Comment,
-			)
-			if err != nil {
-				return nil, err
-			}
-			fieldsInfo = append(fieldsInfo, &fldInfo)
-		}
 		tInfo := TableInfo{
 			TableName: name,
 			Fields:    fieldsInfo,
@@ -116,34 +90,151 @@ func ScanTables(db *sql.DB) (map[string]*TableInfo, error) {
 }
 
 type DbExplorer struct {
-	Logger     *log.Logger
-	Db         *sql.DB
+	Logger *log.Logger
+	// Name - имя базы в MultiDbExplorer, пустое, если база одна
+	Name string
+	Db   *sql.DB
+	// ReadDb - необязательное отдельное подключение (например, пользователем без прав на запись) для GET запросов
+	ReadDb  *sql.DB
+	Dialect Dialect
+	Config  *Config
+	// TablesInfo целиком подменяется при перезагрузке схемы, читать её нужно через tables() и tableInfo()
 	TablesInfo map[string]*TableInfo
+	schemaMu   sync.RWMutex
+	schemaSum  string
+	// reloadMu - перезагрузки схемы не выполняются одновременно
+	reloadMu sync.Mutex
+	// apiKeys перечитываются вместе со схемой
+	apiKeys []apiKey
+	// jwt - nil, если JWT не настроен
+	jwt *jwtVerifier
+	// policy - nil, если доступ ограничивается только аутентификацией и scope
+	policy *Policy
+	// writes - режим только для чтения и отключённые методы, меняются через /_admin/read_only
+	writeMu sync.RWMutex
+	writes  writeState
+	// cache - nil, если кеш чтений выключен
+	cache *readCache
+}
+
+// actionMethod - метод служебного маршрута записи или таблицы вроде /$table/$id/_history,
+// пустая строка - это не служебный маршрут. Другие методы на них не должны доходить до обработчиков записей
+func actionMethod(urlPath string) string {
+	switch slashes := strings.Count(urlPath, "/"); {
+	case slashes == 3 && strings.HasSuffix(urlPath, "/_history"):
+		return http.MethodGet
+	case slashes == 3 && strings.HasSuffix(urlPath, "/_restore"):
+		return http.MethodPost
+	case slashes == 2 && strings.HasSuffix(urlPath, "/_import"):
+		return http.MethodPost
+	}
+	return ""
 }
 
 func (e *DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w = withEncoder(w, r)
+	if e.Config.Timeouts.Query > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), e.Config.Timeouts.Query)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+	if e.authEnabled() {
+		principal, err := e.authenticate(r)
+		if err != nil {
+			e.Logger.Printf("%s %s from %s: %s", r.Method, r.URL.Path, r.RemoteAddr, err)
+			sendUnauthorized(w, err.Error())
+			return
+		}
+		r = r.WithContext(withPrincipal(r.Context(), principal))
+		e.logRequest(r, r.Method, r.URL.Path)
+		if scope := routeScope(r.Method, r.URL.Path); scope != "" && !principal.hasScope(scope) {
+			sendErrResponse(w, "insufficient scope, "+scope+" required", http.StatusForbidden)
+			return
+		}
+		if e.policy != nil {
+			r, err = e.authorize(r, principal)
+			if err != nil {
+				e.logRequest(r, err)
+				sendErrResponse(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+	}
+	if _, err := negotiateFormat(r, routeFormats(r.Method, r.URL.Path)...); err != nil {
+		sendFormatError(w, err)
+		return
+	}
+	if table := routeTable(r.URL.Path); table != "_admin" {
+		if err := e.checkWritable(r.Method, table); err != nil {
+			sendErrResponse(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	if r.Method == http.MethodGet {
+		r = r.WithContext(context.WithValue(r.Context(), readRequestKey{}, true))
+	}
+	if table := routeTable(r.URL.Path); table != "" {
+		var err error
+		r, err = e.withRowFilter(r, table)
+		if err != nil {
+			e.logRequest(r, err)
+			sendErrResponse(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+	if r.URL.Path == "/_admin/reload" {
+		e.handlerReloadSchema(w, r)
+		return
+	}
+	if r.URL.Path == "/_admin/read_only" {
+		e.handlerReadOnly(w, r)
+		return
+	}
+	if r.URL.Path == "/_admin/cache" {
+		e.handlerCache(w, r)
+		return
+	}
+	if allowed := actionMethod(r.URL.Path); allowed != "" && r.Method != allowed {
+		w.Header().Set("Allow", allowed)
+		sendErrResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		if r.URL.Path == "/" {
 			e.handlerAllTableNames(w, r)
 			return
 		}
-		if strings.Count(r.URL.Path, "/") == 1 && !r.URL.Query().Has("limit") && !r.URL.Query().Has("offset") {
-			slashPos := strings.Index(r.URL.Path, "/")
-			tableName := r.URL.Path[slashPos+1:]
-			e.handlerAllRecords(tableName)(w, r)
+		if r.URL.Path == "/_openapi.json" {
+			e.handlerOpenAPI(w, r)
+			return
+		}
+		if r.URL.Path == "/_audit" {
+			e.handlerAudit(w, r)
+			return
+		}
+		if r.URL.Path == "/_dump" {
+			e.handlerDump(w, r)
+			return
+		}
+		if strings.Count(r.URL.Path, "/") == 1 {
+			tableName := strings.TrimPrefix(r.URL.Path, "/")
+			e.handlerRecords(tableName)(w, r)
+			return
+		}
+		if strings.Count(r.URL.Path, "/") == 3 && strings.HasSuffix(r.URL.Path, "/_history") {
+			data := strings.Split(strings.TrimLeft(r.URL.Path, "/"), "/")
+			e.handlerRecordHistory(data[0], data[1])(w, r)
 			return
 		}
-		if strings.Count(r.URL.Path, "/") == 1 && r.URL.Query().Has("limit") && !r.URL.Query().Has("offset") { // only limit
-			slashPos := strings.Index(r.URL.Path, "/")
-			tableName := r.URL.Path[slashPos+1:]
-			e.handlerAllRecordsWithLimit(tableName)(w, r)
+		if strings.Count(r.URL.Path, "/") == 2 && strings.HasSuffix(r.URL.Path, "/_jsonschema") {
+			tableName := strings.TrimSuffix(strings.TrimLeft(r.URL.Path, "/"), "/_jsonschema")
+			e.handlerJSONSchema(tableName)(w, r)
 			return
 		}
-		if strings.Count(r.URL.Path, "/") == 1 && r.URL.Query().Has("limit") && r.URL.Query().Has("offset") {
-			slashPos := strings.Index(r.URL.Path, "/")
-			tableName := r.URL.Path[slashPos+1:]
-			e.handlerAllRecordsWithLimitAndOffset(tableName)(w, r)
+		if strings.Count(r.URL.Path, "/") == 2 && strings.HasSuffix(r.URL.Path, "/_dump") {
+			tableName := strings.TrimSuffix(strings.TrimLeft(r.URL.Path, "/"), "/_dump")
+			e.handlerTableDump(tableName)(w, r)
 			return
 		}
 		if strings.Count(r.URL.Path, "/") == 2 {
@@ -158,6 +249,16 @@ func (e *DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		e.handlerAddRecordToTable(tableName)(w, r)
 		return
 	case http.MethodPost:
+		if strings.Count(r.URL.Path, "/") == 2 && strings.HasSuffix(r.URL.Path, "/_import") {
+			tableName := strings.TrimSuffix(strings.TrimLeft(r.URL.Path, "/"), "/_import")
+			e.handlerImport(tableName)(w, r)
+			return
+		}
+		if strings.Count(r.URL.Path, "/") == 3 && strings.HasSuffix(r.URL.Path, "/_restore") {
+			data := strings.Split(strings.TrimLeft(r.URL.Path, "/"), "/")
+			e.handlerRestoreRecord(data[0], data[1])(w, r)
+			return
+		}
 		data := strings.Split(strings.TrimLeft(r.URL.Path, "/"), "/")
 		tableName := data[0]
 		id := data[1]
@@ -172,176 +273,250 @@ func (e *DbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// NewDbExplorer определяет диалект по драйверу, которым открыт db
 func NewDbExplorer(db *sql.DB) (*DbExplorer, error) {
-	tablesInfo, err := ScanTables(db)
+	dialect, err := detectDialect(db)
+	if err != nil {
+		return nil, err
+	}
+	return NewDbExplorerWithDialect(db, dialect)
+}
+
+func NewDbExplorerWithDialect(db *sql.DB, dialect Dialect) (*DbExplorer, error) {
+	return NewDbExplorerWithConfig(db, dialect, DefaultConfig())
+}
+
+// NewDbExplorerWithConfig - если dialect nil, он определяется по драйверу
+func NewDbExplorerWithConfig(db *sql.DB, dialect Dialect, cfg *Config) (*DbExplorer, error) {
+	err := cfg.OpenFiles()
 	if err != nil {
 		return nil, err
 	}
-	logger := log.New(os.Stdout, "", log.Lshortfile)
+	if dialect == nil {
+		dialect, err = detectDialect(db)
+		if err != nil {
+			return nil, err
+		}
+	}
 	explorer := DbExplorer{
-		Db:         db,
-		Logger:     logger,
-		TablesInfo: tablesInfo,
+		Db:      db,
+		Dialect: dialect,
+		Logger:  cfg.Log.Logger(),
+		Config:  cfg,
+		writes:  newWriteState(cfg),
+	}
+	if cfg.Cache.Size > 0 {
+		explorer.cache = newReadCache(cfg.Cache.Size)
+	}
+	tablesInfo, err := explorer.scanTables()
+	if err != nil {
+		return nil, err
+	}
+	explorer.TablesInfo = tablesInfo
+	explorer.apiKeys, err = explorer.loadAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Auth.JWT.enabled() {
+		explorer.jwt, err = newJWTVerifier(cfg.Auth.JWT)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Auth.PolicyFile != "" {
+		explorer.policy, err = loadPolicy(cfg.Auth.PolicyFile)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &explorer, nil
 }
 
-func (e *DbExplorer) handlerAllTableNames(w http.ResponseWriter, r *http.Request) {
-	rows, err := e.Db.Query("SHOW TABLES")
+// scanTables читает схему и оставляет только те таблицы и поля, которые разрешено отдавать
+func (e *DbExplorer) scanTables() (map[string]*TableInfo, error) {
+	tablesInfo, err := ScanTables(e.Db, e.Dialect)
 	if err != nil {
-		e.Logger.Println(err)
-		sendJSONErrResponse(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
-	tables := make([]string, 0)
-	for rows.Next() {
-		var name string
-		err := rows.Scan(&name)
+	for name, tableInfo := range tablesInfo {
+		if !e.Config.isTableExposed(name) || name == e.Config.Auth.APIKeysTable || name == e.Config.Audit.Table || name == e.Config.History.Table {
+			delete(tablesInfo, name)
+			continue
+		}
+		tc, ok := e.Config.TableSettings[name]
+		if !ok {
+			continue
+		}
+		fields := make([]*FieldInfo, 0, len(tableInfo.Fields))
+		for _, fldInfo := range tableInfo.Fields {
+			if matchAny(tc.HiddenColumns, fldInfo.Field) {
+				if fldInfo.Key == "PRI" {
+					return nil, fmt.Errorf("primary key %s of table %s can't be hidden", fldInfo.Field, name)
+				}
+				tableInfo.HasHiddenColumns = true
+				continue
+			}
+			fldInfo.ReadOnly = matchAny(tc.ReadOnlyColumns, fldInfo.Field)
+			fldInfo.WriteOnly = matchAny(tc.WriteOnlyColumns, fldInfo.Field)
+			fields = append(fields, fldInfo)
+		}
+		tableInfo.Fields = fields
+		tableInfo.RowPolicy, err = parseRowPolicy(tc.RowPolicy)
 		if err != nil {
-			e.Logger.Println(err)
-			sendJSONErrResponse(w, err.Error(), http.StatusInternalServerError)
-			return
+			return nil, fmt.Errorf("table %s: %w", name, err)
+		}
+		for _, p := range tableInfo.RowPolicy {
+			if tableInfo.getFieldInfoByName(p.Column) == nil {
+				return nil, fmt.Errorf("table %s: row policy column %s not found", name, p.Column)
+			}
+		}
+		if tc.SoftDelete != "" {
+			fldInfo := tableInfo.getFieldInfoByName(tc.SoftDelete)
+			if fldInfo == nil {
+				return nil, fmt.Errorf("table %s: soft delete column %s not found", name, tc.SoftDelete)
+			}
+			// отметку ставят только DELETE и _restore
+			fldInfo.ReadOnly = true
+			tableInfo.SoftDelete = newSoftDelete(fldInfo)
 		}
-		tables = append(tables, name)
 	}
-	resp := map[string]interface{}{"tables": tables}
-	wrapped := map[string]interface{}{"response": resp}
-	js, _ := json.MarshalIndent(&wrapped, "", "   ")
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(js)
+	return tablesInfo, nil
 }
 
-func (e *DbExplorer) handlerAllRecords(tableName string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		_, tableExists := e.TablesInfo[tableName]
-		if !tableExists {
-			sendJSONErrResponse(w, "unknown table", http.StatusNotFound)
-		} else {
-			rows, err := e.getAllRowsFromTable(tableName)
-			if err != nil {
-				e.Logger.Println(err)
-				errResp := map[string]string{"error": err.Error()}
-				js, _ := json.MarshalIndent(&errResp, "", "   ")
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write(js)
-				return
-			}
-			records := map[string]interface{}{"records": rows}
-			response := map[string]interface{}{"response": records}
-			js, _ := json.MarshalIndent(&response, "", "   ")
-			w.Header().Set("Content-Type", "application/json")
-			w.Write(js)
-		}
+func (e *DbExplorer) handlerAllTableNames(w http.ResponseWriter, r *http.Request) {
+	tablesInfo := e.tables()
+	tables := make([]string, 0, len(tablesInfo))
+	for name := range tablesInfo {
+		tables = append(tables, name)
 	}
+	sort.Strings(tables)
+	resp := map[string]interface{}{"tables": e.visibleTables(r, tables)}
+	sendResponse(w, map[string]interface{}{"response": resp}, http.StatusOK)
 }
 
-func (e *DbExplorer) handlerAllRecordsWithLimit(tableName string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		_, tableExists := e.TablesInfo[tableName]
-		if !tableExists {
-			sendJSONErrResponse(w, "unknown table", http.StatusNotFound)
-		} else {
-			qLimit := r.URL.Query().Get("limit")
-			if qLimit == "" {
-				limit := 5
-				rows, err := e.getRowsFromTableByLimit(tableName, limit)
-				if err != nil {
-					sendJSONErrResponse(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				records := map[string]interface{}{"records": rows}
-				response := map[string]interface{}{"response": records}
-				js, _ := json.MarshalIndent(&response, "", "   ")
-				w.Header().Set("Content-Type", "application/json")
-				w.Write(js)
-				return
-			} else {
-				lim, err := strconv.Atoi(qLimit)
-				if err != nil {
-					lim = 5
-				}
-				rows, err := e.getRowsFromTableByLimit(tableName, lim)
-				if err != nil {
-					sendJSONErrResponse(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				records := map[string]interface{}{"records": rows}
-				response := map[string]interface{}{"response": records}
-				js, _ := json.MarshalIndent(&response, "", "   ")
-				w.Header().Set("Content-Type", "application/json")
-				w.Write(js)
-				return
-			}
+// parsePage разбирает limit и offset. limit по умолчанию берётся из настроек таблицы,
+// больше max_limit не отдаём, limit=all (только если разрешено) возвращается как -1
+func parsePage(query url.Values, page PaginationConfig) (int64, int64, error) {
+	limit := int64(page.DefaultLimit)
+	offset := int64(0)
+	if query.Has("offset") {
+		off, err := strconv.ParseInt(query.Get("offset"), 10, 64)
+		if err != nil || off < 0 {
+			return 0, 0, errors.New("bad offset value")
+		}
+		offset = off
+	}
+	if !query.Has("limit") {
+		return limit, offset, nil
+	}
+	qLimit := query.Get("limit")
+	if qLimit == "all" {
+		if !page.AllowUnbounded {
+			return 0, 0, errors.New("unbounded queries are not allowed for this table")
+		}
+		if offset != 0 {
+			return 0, 0, errors.New("offset can't be used with limit=all")
 		}
+		return -1, 0, nil
+	}
+	lim, err := strconv.ParseInt(qLimit, 10, 64)
+	if err != nil || lim < 0 {
+		return 0, 0, errors.New("bad limit value")
 	}
+	if page.MaxLimit > 0 && lim > int64(page.MaxLimit) {
+		lim = int64(page.MaxLimit)
+	}
+	return lim, offset, nil
 }
 
-func (e *DbExplorer) handlerAllRecordsWithLimitAndOffset(tableName string) http.HandlerFunc {
+func (e *DbExplorer) handlerRecords(tableName string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, tableExists := e.TablesInfo[tableName]
+		tableInfo, tableExists := e.tableInfoFor(r, tableName)
 		if !tableExists {
-			sendJSONErrResponse(w, "unknown table", http.StatusNotFound)
-		} else {
-			qLimit := r.URL.Query().Get("limit")
-			if qLimit == "" {
-				qLimit = "5"
-			}
-			qOffset := r.URL.Query().Get("offset")
-			if qOffset == "" {
-				qOffset = "0"
-			}
-			lim, err := strconv.ParseInt(qLimit, 10, 64)
-			if err != nil {
-				lim = 5
-			}
-			off, err := strconv.ParseInt(qOffset, 10, 64)
-			if err != nil {
-				off = 0
-			}
-			rows, err := e.getRowsFromTableByLimitAndOffset(tableName, lim, off)
-			if err != nil {
-				e.Logger.Println(err.Error())
-				sendJSONErrResponse(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			records := map[string]interface{}{"records": rows}
-			response := map[string]interface{}{"response": records}
-			js, _ := json.MarshalIndent(&response, "", "   ")
-			w.Header().Set("Content-Type", "application/json")
-			w.Write(js)
+			sendErrResponse(w, "unknown table", http.StatusNotFound)
+			return
+		}
+		limit, offset, err := parsePage(r.URL.Query(), e.Config.pagination(tableName))
+		if err != nil {
+			sendErrResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r, err = withIncludeDeleted(r)
+		if err != nil {
+			sendErrResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		format, err := negotiateFormat(r, listFormats...)
+		if err != nil {
+			sendFormatError(w, err)
+			return
+		}
+		if format == formatNDJSON {
+			e.streamNDJSON(w, r, tableInfo, limit, offset)
+			return
+		}
+		rows, err := e.cachedRows(w, r, tableInfo, limit, offset)
+		if err != nil {
+			e.logRequest(r, err)
+			sendErrResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if format == formatCSV {
+			e.sendCSV(w, r, tableInfo, rows)
 			return
 		}
+		if e.writeCacheHeaders(w, r, tableName, contentETag(rows), e.lastModified(tableInfo, rows...)) {
+			return
+		}
+		records := map[string]interface{}{"records": rows}
+		response := map[string]interface{}{"response": records}
+		encodeResponse(w, responseEncoders[format], response, http.StatusOK)
 	}
 }
 
 func (e *DbExplorer) handlerRecordById(tableName string, queryId string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, tableExists := e.TablesInfo[tableName]
+		tableInfo, tableExists := e.tableInfoFor(r, tableName)
 		if !tableExists {
-			sendJSONErrResponse(w, "unknown table", http.StatusNotFound)
+			sendErrResponse(w, "unknown table", http.StatusNotFound)
 		} else {
 			id, err := strconv.ParseInt(queryId, 10, 64)
 			if err != nil {
-				sendJSONErrResponse(w, "bad id value", http.StatusBadRequest)
+				sendErrResponse(w, "bad id value", http.StatusBadRequest)
 				return
 			}
-			row, err := e.getRowFromTableById(tableName, id)
+			r, err = withIncludeDeleted(r)
 			if err != nil {
-				if errors.Is(err, sql.ErrNoRows) {
-					sendJSONErrResponse(w, "record not found", http.StatusNotFound)
+				sendErrResponse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var row map[string]interface{}
+			if r.URL.Query().Has("as_of") {
+				row, err = e.handleAsOf(r, tableInfo, id)
+			} else {
+				row, err = e.cachedRow(w, r, tableInfo, id)
+			}
+			if err != nil {
+				var asOfErr *asOfError
+				if errors.As(err, &asOfErr) {
+					sendErrResponse(w, err.Error(), asOfErr.status)
+					return
+				}
+				if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errNoRecordAsOf) {
+					sendErrResponse(w, "record not found", http.StatusNotFound)
 					return
 				} else {
-					sendJSONErrResponse(w, err.Error(), http.StatusInternalServerError)
+					sendErrResponse(w, err.Error(), http.StatusInternalServerError)
 					return
 				}
 			}
 			record := map[string]interface{}{"record": row}
 			response := map[string]interface{}{"response": record}
-			js, _ := json.MarshalIndent(&response, "", "   ")
-			w.Header().Set("Content-Type", "application/json")
-			w.Write(js)
+			if !r.URL.Query().Has("as_of") && e.writeCacheHeaders(w, r, tableName, rowETag(row), e.lastModified(tableInfo, row)) {
+				return
+			}
+			sendResponse(w, response, http.StatusOK)
 			return
 		}
 	}
@@ -349,30 +524,33 @@ func (e *DbExplorer) handlerRecordById(tableName string, queryId string) http.Ha
 
 func (e *DbExplorer) handlerAddRecordToTable(tableName string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tableInfo, exists := e.TablesInfo[tableName]
+		tableInfo, exists := e.tableInfo(tableName)
 		if !exists {
-			sendJSONErrResponse(w, "unknown table", http.StatusNotFound)
+			sendErrResponse(w, "unknown table", http.StatusNotFound)
 			return
 		} else {
 			record := make(map[string]interface{})
 			err := json.NewDecoder(r.Body).Decode(&record)
 			if err != nil {
-				e.Logger.Println(err)
-				sendJSONErrResponse(w, err.Error(), http.StatusInternalServerError)
+				e.logRequest(r, err)
+				sendErrResponse(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			lastInsertId, err := e.addRowToTable(tableName, record)
+			lastInsertId, err := e.addRowToTable(r.Context(), tableInfo, record)
 			if err != nil {
+				var fldErr *fieldError
+				if errors.As(err, &fldErr) {
+					sendErrResponse(w, err.Error(), http.StatusBadRequest)
+					return
+				}
 				//e.Logger.Println(err)
-				sendJSONErrResponse(w, err.Error(), http.StatusInternalServerError)
+				sendErrResponse(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
 			pkName := tableInfo.findPrimKeyName()
 			id := map[string]interface{}{*pkName: lastInsertId}
 			response := map[string]interface{}{"response": id}
-			js, _ := json.MarshalIndent(&response, "", "   ")
-			w.Header().Set("Content-Type", "application/json")
-			w.Write(js)
+			sendResponse(w, response, http.StatusOK)
 			return
 		}
 	}
@@ -385,61 +563,70 @@ type Response struct {
 
 func (e *DbExplorer) handlerUpdateRecord(tableName string, queryId string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, exists := e.TablesInfo[tableName]
+		tableInfo, exists := e.tableInfo(tableName)
 		if !exists {
-			sendJSONErrResponse(w, "unknown table", http.StatusNotFound)
+			sendErrResponse(w, "unknown table", http.StatusNotFound)
 			return
 		}
 		id, err := strconv.ParseInt(queryId, 10, 64)
 		if err != nil {
-			sendJSONErrResponse(w, err.Error(), http.StatusBadRequest)
+			sendErrResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r, err = e.withIfMatch(r, tableName)
+		if err != nil {
+			sendErrResponse(w, err.Error(), http.StatusPreconditionRequired)
 			return
 		}
 		record := make(map[string]interface{})
 		err = json.NewDecoder(r.Body).Decode(&record)
 		if err != nil {
-			e.Logger.Println(err)
-			sendJSONErrResponse(w, err.Error(), http.StatusInternalServerError)
+			e.logRequest(r, err)
+			sendErrResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		resp := e.updateRecordTable(tableName, id, record)
+		resp := e.updateRecordTable(r.Context(), tableInfo, id, record)
 		if resp.Err != nil {
 			//e.Logger.Println(err)
-			sendJSONErrResponse(w, resp.Err.Error(), resp.StatusCode)
+			sendErrResponse(w, resp.Err.Error(), resp.StatusCode)
 			return
 		}
 		upd := map[string]interface{}{"updated": 1}
 		wrapped := map[string]interface{}{"response": upd}
-		js, _ := json.MarshalIndent(&wrapped, "", "   ")
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(resp.StatusCode)
-		w.Write(js)
+		sendResponse(w, wrapped, resp.StatusCode)
 	}
 }
 
 func (e *DbExplorer) handlerDeleteRecordFromTable(tableName string, queryId string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		_, exists := e.TablesInfo[tableName]
+		tableInfo, exists := e.tableInfo(tableName)
 		if !exists {
-			sendJSONErrResponse(w, "unknown table", http.StatusNotFound)
+			sendErrResponse(w, "unknown table", http.StatusNotFound)
 			return
 		}
 		id, err := strconv.ParseInt(queryId, 10, 64)
 		if err != nil {
-			e.Logger.Println(err)
-			sendJSONErrResponse(w, err.Error(), http.StatusBadRequest)
+			e.logRequest(r, err)
+			sendErrResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r, err = e.withIfMatch(r, tableName)
+		if err != nil {
+			sendErrResponse(w, err.Error(), http.StatusPreconditionRequired)
+			return
+		}
+		rowsAffected, err := e.deleteRecordById(r.Context(), tableInfo, id)
+		if errors.Is(err, errPreconditionFailed) {
+			sendErrResponse(w, err.Error(), http.StatusPreconditionFailed)
 			return
 		}
-		rowsAffected, err := e.deleteRecordById(tableName, id)
 		if err != nil {
-			e.Logger.Println(err)
-			sendJSONErrResponse(w, err.Error(), http.StatusInternalServerError)
+			e.logRequest(r, err)
+			sendErrResponse(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		deleted := map[string]interface{}{"deleted": rowsAffected}
 		resp := map[string]interface{}{"response": deleted}
-		js, _ := json.MarshalIndent(&resp, "", "   ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(js)
+		sendResponse(w, resp, http.StatusOK)
 	}
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Dialect скрывает всё, чем базы отличаются друг от друга: получение списка таблиц и полей,
+// экранирование имён, плейсхолдеры, пагинацию и получение сгенерированного первичного ключа.
+// Запросы в db_access.go собираются только через него
+type Dialect interface {
+	Name() string
+	// TableNames возвращает имена таблиц текущей базы в алфавитном порядке, без представлений
+	TableNames(db *sql.DB) ([]string, error)
+	// Columns возвращает описание полей таблицы в том виде, в котором его отдаёт SHOW FULL COLUMNS в MySQL
+	Columns(db *sql.DB, table string) ([]*FieldInfo, error)
+	// SchemaChecksumQuery - запрос, по результату которого считается контрольная сумма схемы
+	SchemaChecksumQuery() string
+	QuoteIdent(name string) string
+	// Placeholder возвращает плейсхолдер для n-го (с единицы) аргумента запроса
+	Placeholder(n int) string
+	LimitOffset(limit, offset string) string
+	// ForUpdate - окончание SELECT, которое блокирует прочитанные строки до конца транзакции
+	ForUpdate() string
+	// Insert выполняет INSERT и возвращает значение сгенерированного первичного ключа pk
+	Insert(ctx context.Context, ex dbExecutor, query string, pk string, args []interface{}) (int64, error)
+	// CreateTable возвращает CREATE TABLE для дампа и запрос, который выполняется после вставки строк таблицы,
+	// например сдвигает последовательность первичного ключа, или пустую строку
+	CreateTable(ctx context.Context, ex dbExecutor, tableInfo *TableInfo) (string, string, error)
+	// QuoteString и QuoteBytes - строковый и двоичный литералы для INSERT в дампе
+	QuoteString(s string) string
+	QuoteBytes(b []byte) string
+	// DumpWrap - начало и конец скрипта дампа: кодировка, отключение проверки внешних ключей, транзакция
+	DumpWrap() (string, string)
+}
+
+// dbExecutor - общее у *sql.DB и *sql.Tx
+type dbExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+var dialects = map[string]Dialect{
+	"mysql":    mysqlDialect{},
+	"postgres": postgresDialect{},
+	"pgx":      postgresDialect{},
+	"sqlite3":  sqliteDialect{},
+}
+
+// DialectByDriver возвращает диалект по имени драйвера, с которым открывали sql.Open
+func DialectByDriver(driverName string) (Dialect, error) {
+	d, ok := dialects[driverName]
+	if !ok {
+		return nil, fmt.Errorf("unsupported driver %q", driverName)
+	}
+	return d, nil
+}
+
+// detectDialect определяет диалект по типу драйвера, которым открыт db
+func detectDialect(db *sql.DB) (Dialect, error) {
+	switch driverType := reflect.TypeOf(db.Driver()).String(); driverType {
+	case "*mysql.MySQLDriver":
+		return mysqlDialect{}, nil
+	case "*pq.Driver", "*stdlib.Driver":
+		return postgresDialect{}, nil
+	case "*sqlite3.SQLiteDriver":
+		return sqliteDialect{}, nil
+	default:
+		return nil, fmt.Errorf("can't detect sql dialect for driver %s", driverType)
+	}
+}
+
+// sqlArgs накапливает аргументы запроса и выдаёт для каждого плейсхолдер нужного диалекта
+type sqlArgs struct {
+	dialect Dialect
+	values  []interface{}
+}
+
+func (a *sqlArgs) add(v interface{}) string {
+	a.values = append(a.values, v)
+	return a.dialect.Placeholder(len(a.values))
+}
+
+func questionPlaceholder(n int) string {
+	return "?"
+}
+
+func dollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// quoteStandardString - литерал по стандарту SQL, в котором экранируется только кавычка
+func quoteStandardString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func limitOffset(limit, offset string) string {
+	return fmt.Sprintf(" LIMIT %s OFFSET %s", limit, offset)
+}
+
+// scanStrings читает все строки результата, в котором все колонки можно прочитать как строки
+func scanStrings(rows *sql.Rows) ([][]sql.NullString, error) {
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := make([][]sql.NullString, 0)
+	for rows.Next() {
+		values := make([]sql.NullString, len(cols))
+		pointers := make([]interface{}, len(cols))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, values)
+	}
+	return result, rows.Err()
+}
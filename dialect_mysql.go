@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string {
+	return "mysql"
+}
+
+func (mysqlDialect) TableNames(db *sql.DB) ([]string, error) {
+	// SHOW TABLES отдаёт и представления, а их, как и в остальных диалектах, не показываем
+	rows, err := db.Query(`SELECT TABLE_NAME FROM information_schema.TABLES
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'BASE TABLE'
+ORDER BY TABLE_NAME`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tableNames := make([]string, 0)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		tableNames = append(tableNames, name)
+	}
+	return tableNames, rows.Err()
+}
+
+func (d mysqlDialect) Columns(db *sql.DB, table string) ([]*FieldInfo, error) {
+	rows, err := db.Query(fmt.Sprintf("SHOW FULL COLUMNS FROM %s", d.QuoteIdent(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fieldsInfo := make([]*FieldInfo, 0)
+	for rows.Next() {
+		fldInfo := FieldInfo{}
+		err = rows.Scan(
+			&fldInfo.Field,
+			&fldInfo.Type,
+			&fldInfo.Collation,
+			&fldInfo.Null,
+			&fldInfo.Key,
+			&fldInfo.Default,
+			&fldInfo.Extra,
+			&fldInfo.Privileges,
+			&fldInfo.Comment,
+		)
+		if err != nil {
+			return nil, err
+		}
+		fieldsInfo = append(fieldsInfo, &fldInfo)
+	}
+	return fieldsInfo, rows.Err()
+}
+
+func (mysqlDialect) SchemaChecksumQuery() string {
+	return `SELECT TABLE_NAME, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_KEY, COLUMN_DEFAULT, EXTRA, COLUMN_COMMENT
+FROM information_schema.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE()
+ORDER BY TABLE_NAME, ORDINAL_POSITION`
+}
+
+func (mysqlDialect) QuoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func (mysqlDialect) Placeholder(n int) string {
+	return questionPlaceholder(n)
+}
+
+func (mysqlDialect) LimitOffset(limit, offset string) string {
+	return limitOffset(limit, offset)
+}
+
+func (mysqlDialect) ForUpdate() string {
+	return " FOR UPDATE"
+}
+
+func (mysqlDialect) Insert(ctx context.Context, ex dbExecutor, query string, pk string, args []interface{}) (int64, error) {
+	result, err := ex.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (d mysqlDialect) CreateTable(ctx context.Context, ex dbExecutor, tableInfo *TableInfo) (string, string, error) {
+	var name, create string
+	err := ex.QueryRowContext(ctx, "SHOW CREATE TABLE "+d.QuoteIdent(tableInfo.TableName)).Scan(&name, &create)
+	return create, "", err
+}
+
+// mysqlEscaper экранирует то же, что mysql_real_escape_string
+var mysqlEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"'", "\\'",
+	"\"", "\\\"",
+	"\x00", "\\0",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\x1a", "\\Z",
+)
+
+func (mysqlDialect) QuoteString(s string) string {
+	return "'" + mysqlEscaper.Replace(s) + "'"
+}
+
+func (mysqlDialect) QuoteBytes(b []byte) string {
+	if len(b) == 0 {
+		return "''"
+	}
+	return "0x" + hex.EncodeToString(b)
+}
+
+// DumpWrap - те же установки, что пишет mysqldump
+func (mysqlDialect) DumpWrap() (string, string) {
+	return `/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET NAMES utf8mb4 */;
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+`, `/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
+`
+}
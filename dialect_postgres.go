@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// postgresDialect работает с таблицами из текущей схемы (search_path), описание полей берётся из information_schema
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return "postgres"
+}
+
+func (postgresDialect) TableNames(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT table_name FROM information_schema.tables
+WHERE table_schema = current_schema() AND table_type = 'BASE TABLE'
+ORDER BY table_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tableNames := make([]string, 0)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		tableNames = append(tableNames, name)
+	}
+	return tableNames, rows.Err()
+}
+
+func (postgresDialect) Columns(db *sql.DB, table string) ([]*FieldInfo, error) {
+	rows, err := db.Query(`SELECT c.column_name,
+       c.data_type,
+       c.character_maximum_length,
+       c.numeric_precision,
+       c.numeric_scale,
+       c.collation_name,
+       c.is_nullable,
+       c.column_default,
+       c.is_identity,
+       COALESCE(col_description(format('%I.%I', c.table_schema, c.table_name)::regclass, c.ordinal_position::int), ''),
+       EXISTS (
+           SELECT 1 FROM information_schema.table_constraints tc
+           JOIN information_schema.key_column_usage kcu
+             ON kcu.constraint_name = tc.constraint_name
+            AND kcu.table_schema = tc.table_schema
+            AND kcu.table_name = tc.table_name
+           WHERE tc.constraint_type = 'PRIMARY KEY'
+             AND tc.table_schema = c.table_schema
+             AND tc.table_name = c.table_name
+             AND kcu.column_name = c.column_name
+       )
+FROM information_schema.columns c
+WHERE c.table_schema = current_schema() AND c.table_name = $1
+ORDER BY c.ordinal_position`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fieldsInfo := make([]*FieldInfo, 0)
+	for rows.Next() {
+		var (
+			fldInfo                     FieldInfo
+			dataType, identity          string
+			maxLength, precision, scale sql.NullInt64
+			isPrimary                   bool
+		)
+		err = rows.Scan(
+			&fldInfo.Field,
+			&dataType,
+			&maxLength,
+			&precision,
+			&scale,
+			&fldInfo.Collation,
+			&fldInfo.Null,
+			&fldInfo.Default,
+			&identity,
+			&fldInfo.Comment,
+			&isPrimary,
+		)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case maxLength.Valid:
+			fldInfo.Type = fmt.Sprintf("%s(%d)", dataType, maxLength.Int64)
+		case dataType == "numeric" && precision.Valid:
+			fldInfo.Type = fmt.Sprintf("%s(%d,%d)", dataType, precision.Int64, scale.Int64)
+		default:
+			fldInfo.Type = dataType
+		}
+		if isPrimary {
+			fldInfo.Key = "PRI"
+		}
+		if identity == "YES" || strings.HasPrefix(fldInfo.Default.String, "nextval(") {
+			fldInfo.Extra = "auto_increment"
+		}
+		fieldsInfo = append(fieldsInfo, &fldInfo)
+	}
+	return fieldsInfo, rows.Err()
+}
+
+func (postgresDialect) SchemaChecksumQuery() string {
+	return `SELECT table_name, column_name, data_type, character_maximum_length::text, is_nullable, column_default
+FROM information_schema.columns
+WHERE table_schema = current_schema()
+ORDER BY table_name, ordinal_position`
+}
+
+func (postgresDialect) QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (postgresDialect) Placeholder(n int) string {
+	return dollarPlaceholder(n)
+}
+
+func (postgresDialect) LimitOffset(limit, offset string) string {
+	return limitOffset(limit, offset)
+}
+
+func (postgresDialect) ForUpdate() string {
+	return " FOR UPDATE"
+}
+
+// Insert - в postgres нет LastInsertId, ключ возвращаем через RETURNING
+func (d postgresDialect) Insert(ctx context.Context, ex dbExecutor, query string, pk string, args []interface{}) (int64, error) {
+	var id int64
+	err := ex.QueryRowContext(ctx, query+" RETURNING "+d.QuoteIdent(pk), args...).Scan(&id)
+	return id, err
+}
+
+// CreateTable - в PostgreSQL нет SHOW CREATE TABLE, таблица собирается из описания колонок:
+// типы, NULL, значения по умолчанию и первичный ключ. Индексы, внешние ключи и прочие ограничения в дамп не попадают.
+// Автоинкрементный ключ становится identity, после вставки строк его последовательность сдвигается на максимум
+func (d postgresDialect) CreateTable(ctx context.Context, ex dbExecutor, tableInfo *TableInfo) (string, string, error) {
+	table := d.QuoteIdent(tableInfo.TableName)
+	lines := make([]string, 0, len(tableInfo.Fields)+1)
+	after := ""
+	for _, fldInfo := range tableInfo.Fields {
+		line := "  " + d.QuoteIdent(fldInfo.Field) + " " + fldInfo.Type
+		if fldInfo.Null == "NO" {
+			line += " NOT NULL"
+		}
+		switch {
+		case fldInfo.Extra == "auto_increment":
+			line += " GENERATED BY DEFAULT AS IDENTITY"
+			col := d.QuoteIdent(fldInfo.Field)
+			after = fmt.Sprintf("SELECT setval(pg_get_serial_sequence(%s, %s), COALESCE(MAX(%s), 0) + 1, false) FROM %s;",
+				quoteStandardString(table), quoteStandardString(fldInfo.Field), col, table)
+		case fldInfo.Default.Valid:
+			line += " DEFAULT " + fldInfo.Default.String
+		}
+		lines = append(lines, line)
+	}
+	if pkName := tableInfo.findPrimKeyName(); pkName != nil {
+		lines = append(lines, "  PRIMARY KEY ("+d.QuoteIdent(*pkName)+")")
+	}
+	return "CREATE TABLE " + table + " (\n" + strings.Join(lines, ",\n") + "\n)", after, nil
+}
+
+// QuoteString рассчитывает на standard_conforming_strings = on, его выставляет DumpWrap
+func (postgresDialect) QuoteString(s string) string {
+	return quoteStandardString(s)
+}
+
+func (postgresDialect) QuoteBytes(b []byte) string {
+	return "'\\x" + hex.EncodeToString(b) + "'::bytea"
+}
+
+func (postgresDialect) DumpWrap() (string, string) {
+	return "SET client_encoding = 'UTF8';\nSET standard_conforming_strings = on;\nBEGIN;\n", "COMMIT;\n"
+}
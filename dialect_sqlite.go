@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+)
+
+// sqliteDialect нужен в первую очередь для локальной разработки и тестов: база может быть в файле или в памяти
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) TableNames(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM sqlite_master
+WHERE type = 'table' AND name NOT LIKE 'sqlite_%'
+ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tableNames := make([]string, 0)
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+		tableNames = append(tableNames, name)
+	}
+	return tableNames, rows.Err()
+}
+
+func (d sqliteDialect) Columns(db *sql.DB, table string) ([]*FieldInfo, error) {
+	rows, err := db.Query("PRAGMA table_info(" + d.QuoteIdent(table) + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fieldsInfo := make([]*FieldInfo, 0)
+	for rows.Next() {
+		var (
+			fldInfo      FieldInfo
+			cid, notNull int
+			pk           int
+		)
+		err = rows.Scan(&cid, &fldInfo.Field, &fldInfo.Type, &notNull, &fldInfo.Default, &pk)
+		if err != nil {
+			return nil, err
+		}
+		fldInfo.Null = "YES"
+		if notNull == 1 || pk > 0 {
+			fldInfo.Null = "NO"
+		}
+		if pk > 0 {
+			fldInfo.Key = "PRI"
+			// INTEGER PRIMARY KEY - это псевдоним rowid, он заполняется сам
+			if strings.EqualFold(fldInfo.Type, "integer") {
+				fldInfo.Extra = "auto_increment"
+			}
+		}
+		fieldsInfo = append(fieldsInfo, &fldInfo)
+	}
+	return fieldsInfo, rows.Err()
+}
+
+func (sqliteDialect) SchemaChecksumQuery() string {
+	return "SELECT type, name, sql FROM sqlite_master ORDER BY type, name"
+}
+
+func (sqliteDialect) QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func (sqliteDialect) Placeholder(n int) string {
+	return questionPlaceholder(n)
+}
+
+func (sqliteDialect) LimitOffset(limit, offset string) string {
+	return limitOffset(limit, offset)
+}
+
+// ForUpdate - в SQLite блокировки строк нет, пишущие транзакции и так выполняются по одной
+func (sqliteDialect) ForUpdate() string {
+	return ""
+}
+
+func (sqliteDialect) Insert(ctx context.Context, ex dbExecutor, query string, pk string, args []interface{}) (int64, error) {
+	result, err := ex.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// CreateTable - SQLite хранит исходный текст CREATE TABLE. Счётчик AUTOINCREMENT после вставки
+// с явными ключами он обновляет сам
+func (sqliteDialect) CreateTable(ctx context.Context, ex dbExecutor, tableInfo *TableInfo) (string, string, error) {
+	var create string
+	err := ex.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", tableInfo.TableName).Scan(&create)
+	return create, "", err
+}
+
+func (sqliteDialect) QuoteString(s string) string {
+	return quoteStandardString(s)
+}
+
+func (sqliteDialect) QuoteBytes(b []byte) string {
+	return "X'" + hex.EncodeToString(b) + "'"
+}
+
+func (sqliteDialect) DumpWrap() (string, string) {
+	return "PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n", "COMMIT;\n"
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestColumnTypes(t *testing.T) {
+	cases := []struct {
+		dialect string
+		typ     string
+		null    string
+		want    columnType
+	}{
+		// MySQL, как его отдаёт SHOW FULL COLUMNS
+		{"mysql", "int(11)", "NO", columnType{Kind: kindInteger}},
+		{"mysql", "int(10) unsigned", "NO", columnType{Kind: kindInteger, Unsigned: true}},
+		{"mysql", "tinyint(1)", "NO", columnType{Kind: kindInteger}},
+		{"mysql", "varchar(255)", "YES", columnType{Kind: kindString, MaxLength: 255, Nullable: true}},
+		{"mysql", "text", "NO", columnType{Kind: kindString, MaxLength: 65535}},
+		{"mysql", "decimal(10,2)", "NO", columnType{Kind: kindFloat}},
+		{"mysql", "double unsigned", "NO", columnType{Kind: kindFloat, Unsigned: true}},
+		{"mysql", "enum('new','it''s')", "NO", columnType{Kind: kindString, Enum: []string{"new", "it's"}}},
+		{"mysql", "datetime", "YES", columnType{Kind: kindString, Nullable: true}},
+		// PostgreSQL, как его собирает postgresDialect.Columns из information_schema
+		{"postgres", "integer", "NO", columnType{Kind: kindInteger}},
+		{"postgres", "bigint", "NO", columnType{Kind: kindInteger}},
+		{"postgres", "character varying(255)", "NO", columnType{Kind: kindString, MaxLength: 255}},
+		{"postgres", "text", "YES", columnType{Kind: kindString, MaxLength: 65535, Nullable: true}},
+		{"postgres", "numeric(10,2)", "NO", columnType{Kind: kindFloat}},
+		{"postgres", "double precision", "NO", columnType{Kind: kindFloat}},
+		{"postgres", "boolean", "NO", columnType{Kind: kindBool}},
+		{"postgres", "timestamp without time zone", "YES", columnType{Kind: kindString, Nullable: true}},
+		// SQLite, объявленный тип из PRAGMA table_info
+		{"sqlite", "INTEGER", "NO", columnType{Kind: kindInteger}},
+		{"sqlite", "varchar(255)", "YES", columnType{Kind: kindString, MaxLength: 255, Nullable: true}},
+		{"sqlite", "REAL", "NO", columnType{Kind: kindFloat}},
+		{"sqlite", "BOOLEAN", "NO", columnType{Kind: kindBool}},
+	}
+	for _, c := range cases {
+		fldInfo := &FieldInfo{Field: "f", Type: c.typ, Null: c.null}
+		if got := fldInfo.columnType(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %s: got %+v, want %+v", c.dialect, c.typ, got, c.want)
+		}
+	}
+}
+
+func TestNormalizeBool(t *testing.T) {
+	ct := columnType{Kind: kindBool}
+	for _, v := range []interface{}{true, false} {
+		if got, err := ct.normalize(v); err != nil || got != v {
+			t.Errorf("normalize(%v) = %v, %v", v, got, err)
+		}
+	}
+	for _, v := range []interface{}{"true", 1.0, nil} {
+		if _, err := ct.normalize(v); err != errInvalidType {
+			t.Errorf("normalize(%#v) must fail with invalid type, got %v", v, err)
+		}
+	}
+	for raw, want := range map[string]bool{"t": true, "f": false, "true": true, "0": false} {
+		if got := ct.decode([]byte(raw)); got != want {
+			t.Errorf("decode(%q) = %v, want %v", raw, got, want)
+		}
+	}
+	if ct.zeroValue() != false {
+		t.Errorf("zero value of bool must be false")
+	}
+	if schema := ct.jsonSchema(); schema["type"] != "boolean" {
+		t.Errorf("bad bool schema %v", schema)
+	}
+}
+
+func TestDialectQuoting(t *testing.T) {
+	cases := []struct {
+		dialect     Dialect
+		rawIdent    string
+		ident       string
+		placeholder string
+		str         string
+		bytes       string
+	}{
+		{mysqlDialect{}, "we`ird", "`we``ird`", "?", `'it\'s \\ \"x\"\n'`, "0xdead"},
+		{postgresDialect{}, `we"ird`, `"we""ird"`, "$2", `'it''s \ "x"` + "\n'", `'\xdead'::bytea`},
+		{sqliteDialect{}, `we"ird`, `"we""ird"`, "?", `'it''s \ "x"` + "\n'", "X'dead'"},
+	}
+	for _, c := range cases {
+		name := c.dialect.Name()
+		if got := c.dialect.QuoteIdent(c.rawIdent); got != c.ident {
+			t.Errorf("%s: QuoteIdent = %s, want %s", name, got, c.ident)
+		}
+		if got := c.dialect.Placeholder(2); got != c.placeholder {
+			t.Errorf("%s: Placeholder(2) = %s, want %s", name, got, c.placeholder)
+		}
+		if got := c.dialect.LimitOffset("1", "2"); got != " LIMIT 1 OFFSET 2" {
+			t.Errorf("%s: LimitOffset = %q", name, got)
+		}
+		if got := c.dialect.QuoteString("it's \\ \"x\"\n"); got != c.str {
+			t.Errorf("%s: QuoteString = %s, want %s", name, got, c.str)
+		}
+		if got := c.dialect.QuoteBytes([]byte{0xde, 0xad}); got != c.bytes {
+			t.Errorf("%s: QuoteBytes = %s, want %s", name, got, c.bytes)
+		}
+	}
+}
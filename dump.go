@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GET /_dump - SQL-скрипт со всеми таблицами базы (нужен scope admin), GET /$table/_dump - с одной таблицей.
+// Скрипт в духе mysqldump: для каждой таблицы DROP TABLE IF EXISTS, CREATE TABLE (в MySQL - из SHOW CREATE TABLE)
+// и INSERT'ы по dump.batch_size строк. Все таблицы читаются в одной транзакции REPEATABLE READ, так что дамп согласован.
+// Скрипт пишется потоком по мере чтения. Полный дамп заканчивается строкой "-- Dump completed",
+// при ошибке посреди потока вместо неё - "-- ERROR: ...".
+// Записи отдаются все, в том числе мягко удалённые. CREATE TABLE берётся из базы со всеми колонками таблицы,
+// а INSERT'ы - только с видимыми, поэтому таблицы, которые автор запроса видит не целиком (политика по колонкам или строкам,
+// hidden_columns, write_only колонки), в дамп не попадают: GET /$table/_dump отвечает на них 403, GET /_dump пропускает их с комментарием в начале скрипта
+
+// dumpMaxStatementBytes - больше в один INSERT не пишем, как net_buffer_length у mysqldump
+const dumpMaxStatementBytes = 1 << 20
+
+// isBinaryColumn - значения таких колонок пишутся двоичным литералом, а не строкой
+func isBinaryColumn(fldInfo *FieldInfo) bool {
+	base := baseType(strings.ToLower(fldInfo.Type))
+	return strings.Contains(base, "blob") || strings.Contains(base, "binary") || base == "bytea"
+}
+
+// sqlLiteral - значение, прочитанное из базы, в виде литерала для INSERT
+func (e *DbExplorer) sqlLiteral(fldInfo *FieldInfo, v interface{}) string {
+	var raw string
+	switch val := v.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if val {
+			return "TRUE"
+		}
+		return "FALSE"
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		raw = strconv.FormatFloat(val, 'g', -1, 64)
+	case time.Time:
+		return e.Dialect.QuoteString(val.Format("2006-01-02 15:04:05.999999999"))
+	case []byte:
+		if isBinaryColumn(fldInfo) {
+			return e.Dialect.QuoteBytes(val)
+		}
+		raw = string(val)
+	case string:
+		raw = val
+	default:
+		raw = fmt.Sprint(val)
+	}
+	switch fldInfo.columnType().Kind {
+	case kindInteger, kindFloat:
+		// NaN и Infinity ParseFloat разбирает, но без кавычек это не число
+		if num, err := strconv.ParseFloat(raw, 64); err == nil && !math.IsNaN(num) && !math.IsInf(num, 0) {
+			return raw
+		}
+	}
+	return e.Dialect.QuoteString(raw)
+}
+
+// dumpRefusal - почему таблицу нельзя отдать в дамп, nil - можно. decision - решение политики о GET таблицы,
+// withPolicy = false - политика не задана
+func dumpRefusal(tableInfo *TableInfo, decision accessDecision, withPolicy bool) error {
+	if withPolicy && !decision.Allowed {
+		return fmt.Errorf("access denied: table %s is not readable", tableInfo.TableName)
+	}
+	if (withPolicy && decision.Columns != nil) || len(tableInfo.RowPolicy) > 0 {
+		return fmt.Errorf("access denied: dump of %s requires access to all its columns and rows", tableInfo.TableName)
+	}
+	if tableInfo.HasHiddenColumns {
+		// имена скрытых колонок не называем: их не должно быть видно и в ошибке
+		return fmt.Errorf("access denied: dump of %s would omit its hidden columns", tableInfo.TableName)
+	}
+	for _, fldInfo := range tableInfo.Fields {
+		if fldInfo.WriteOnly {
+			return fmt.Errorf("access denied: dump of %s would include write only column %s", tableInfo.TableName, fldInfo.Field)
+		}
+	}
+	return nil
+}
+
+// dumpTable пишет структуру и строки таблицы. flush вызывается после каждого INSERT
+func (e *DbExplorer) dumpTable(ctx context.Context, tx *sql.Tx, w *bufio.Writer, tableInfo *TableInfo, flush func() error) error {
+	create, after, err := e.Dialect.CreateTable(ctx, tx, tableInfo)
+	if err != nil {
+		return err
+	}
+	table := e.Dialect.QuoteIdent(tableInfo.TableName)
+	fmt.Fprintf(w, "\n--\n-- Table structure for table %s\n--\n\nDROP TABLE IF EXISTS %s;\n%s;\n", table, table, create)
+
+	fields := tableInfo.Fields
+	columns := make([]string, 0, len(fields))
+	for _, fldInfo := range fields {
+		columns = append(columns, e.Dialect.QuoteIdent(fldInfo.Field))
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(columns, ", "), table)
+	if pkName := tableInfo.findPrimKeyName(); pkName != nil {
+		query += " ORDER BY " + e.Dialect.QuoteIdent(*pkName)
+	}
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	fmt.Fprintf(w, "\n--\n-- Dumping data for table %s\n--\n\n", table)
+
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+	values := make([]interface{}, len(fields))
+	pointers := make([]interface{}, len(fields))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	literals := make([]string, len(fields))
+	batch, size := 0, 0
+	for rows.Next() {
+		if err = rows.Scan(pointers...); err != nil {
+			return err
+		}
+		for i, fldInfo := range fields {
+			literals[i] = e.sqlLiteral(fldInfo, values[i])
+		}
+		tuple := "(" + strings.Join(literals, ",") + ")"
+		if batch > 0 && (batch >= e.Config.Dump.BatchSize || size+len(tuple) > dumpMaxStatementBytes) {
+			w.WriteString(";\n")
+			if err = flush(); err != nil {
+				return err
+			}
+			batch = 0
+		}
+		if batch == 0 {
+			w.WriteString(insert)
+			size = len(insert)
+		} else {
+			w.WriteByte(',')
+		}
+		w.WriteString(tuple)
+		batch++
+		size += len(tuple) + 1
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if batch > 0 {
+		w.WriteString(";\n")
+	}
+	if after != "" {
+		w.WriteString(after + "\n")
+	}
+	return flush()
+}
+
+// writeDump пишет скрипт по таблицам, прочитанным в транзакции tx. skipped - комментарии о пропущенных таблицах
+func (e *DbExplorer) writeDump(ctx context.Context, tx *sql.Tx, out io.Writer, tables []*TableInfo, skipped []string, flush func() error) error {
+	w := bufio.NewWriter(out)
+	flushAll := func() error {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		return flush()
+	}
+	prologue, epilogue := e.Dialect.DumpWrap()
+	fmt.Fprintf(w, "-- db_explorer SQL dump\n--\n-- Dialect: %s\n-- Dumped at: %s\n", e.Dialect.Name(), time.Now().UTC().Format(time.RFC3339))
+	for _, note := range skipped {
+		fmt.Fprintf(w, "-- Skipped: %s\n", note)
+	}
+	fmt.Fprintf(w, "\n%s", prologue)
+	for _, tableInfo := range tables {
+		if err := e.dumpTable(ctx, tx, w, tableInfo, flushAll); err != nil {
+			// то, что уже прочитано, отдаём, чтобы было видно, на чём остановились
+			w.Flush()
+			return err
+		}
+	}
+	fmt.Fprintf(w, "\n%s\n-- Dump completed on %s\n", epilogue, time.Now().UTC().Format(time.RFC3339))
+	return flushAll()
+}
+
+// streamDump отдаёт дамп таблиц. Ошибка до начала ответа - обычный ответ {"error": ...} с кодом, после - строка "-- ERROR: ..." в конце скрипта
+func (e *DbExplorer) streamDump(w http.ResponseWriter, r *http.Request, filename string, tables []*TableInfo, skipped []string) {
+	ctx := r.Context()
+	tx, err := e.reader(ctx).BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		e.logRequest(r, err)
+		sendErrResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	rc := http.NewResponseController(w)
+	flush := func() error {
+		// как и у NDJSON, write timeout сервера отсчитывается заново для каждой порции
+		if e.Config.Timeouts.Write > 0 {
+			rc.SetWriteDeadline(time.Now().Add(e.Config.Timeouts.Write))
+		}
+		if err := rc.Flush(); err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+	w.Header().Set("Content-Type", "application/sql; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.WriteHeader(http.StatusOK)
+	err = e.writeDump(ctx, tx, w, tables, skipped, flush)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		e.logRequest(r, "dump interrupted:", err)
+	case err != nil:
+		e.logRequest(r, err)
+		fmt.Fprintf(w, "\n-- ERROR: %s\n", strings.ReplaceAll(err.Error(), "\n", " "))
+	default:
+		e.logRequest(r, "dumped", len(tables), "tables")
+	}
+}
+
+// handlerDump - GET /_dump, все таблицы в алфавитном порядке. Каждая таблица проверяется по политике так же,
+// как в GET /$table/_dump, недоступные пропускаются
+func (e *DbExplorer) handlerDump(w http.ResponseWriter, r *http.Request) {
+	tablesInfo := e.tables()
+	names := make([]string, 0, len(tablesInfo))
+	for name := range tablesInfo {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	principal := principalFromContext(r.Context())
+	withPolicy := e.policy != nil && principal != nil
+	var roles []string
+	if withPolicy {
+		roles = e.policy.rolesOf(principal)
+	}
+	tables := make([]*TableInfo, 0, len(names))
+	skipped := make([]string, 0)
+	for _, name := range names {
+		var decision accessDecision
+		if withPolicy {
+			decision = e.policy.authorize(roles, name, http.MethodGet)
+		}
+		if err := dumpRefusal(tablesInfo[name], decision, withPolicy); err != nil {
+			skipped = append(skipped, err.Error())
+			continue
+		}
+		tables = append(tables, tablesInfo[name])
+	}
+	e.streamDump(w, r, "dump.sql", tables, skipped)
+}
+
+// handlerTableDump - GET /$table/_dump. Дамп - снимок всей таблицы, поэтому он не отдаётся тем,
+// кто видит её не целиком, см. dumpRefusal
+func (e *DbExplorer) handlerTableDump(tableName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tableInfo, exists := e.tableInfo(tableName)
+		if !exists {
+			sendErrResponse(w, "unknown table", http.StatusNotFound)
+			return
+		}
+		decision, withPolicy := accessFromContext(r.Context())
+		if err := dumpRefusal(tableInfo, decision, withPolicy); err != nil {
+			sendErrResponse(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		e.streamDump(w, r, tableName+".sql", []*TableInfo{tableInfo}, nil)
+	}
+}
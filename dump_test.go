@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestSQLLiteral(t *testing.T) {
+	intField := &FieldInfo{Field: "id", Type: "int(11)"}
+	floatField := &FieldInfo{Field: "price", Type: "numeric(10,2)"}
+	textField := &FieldInfo{Field: "title", Type: "varchar(255)"}
+	blobField := &FieldInfo{Field: "data", Type: "blob"}
+	byteaField := &FieldInfo{Field: "data", Type: "bytea"}
+
+	cases := []struct {
+		dialect Dialect
+		field   *FieldInfo
+		value   interface{}
+		literal string
+	}{
+		{mysqlDialect{}, textField, nil, "NULL"},
+		{mysqlDialect{}, intField, []byte("42"), "42"},
+		{mysqlDialect{}, intField, int64(-7), "-7"},
+		{mysqlDialect{}, floatField, []byte("12.50"), "12.50"},
+		{postgresDialect{}, floatField, []byte("NaN"), "'NaN'"},
+		{sqliteDialect{}, floatField, 0.5, "0.5"},
+		{mysqlDialect{}, textField, []byte("it's \"a\"\\\n\r\x00\x1a"), `'it\'s \"a\"\\\n\r\0\Z'`},
+		{postgresDialect{}, textField, "it's \\", `'it''s \'`},
+		{sqliteDialect{}, textField, true, "TRUE"},
+		{mysqlDialect{}, blobField, []byte{0, 0xff}, "0x00ff"},
+		{mysqlDialect{}, blobField, []byte{}, "''"},
+		{sqliteDialect{}, blobField, []byte{0xab}, "X'ab'"},
+		{postgresDialect{}, byteaField, []byte{0xab}, `'\xab'::bytea`},
+		{sqliteDialect{}, textField, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "'2024-01-02 03:04:05'"},
+	}
+	for idx, c := range cases {
+		e := &DbExplorer{Dialect: c.dialect}
+		if got := e.sqlLiteral(c.field, c.value); got != c.literal {
+			t.Errorf("case %d (%s): got %s, want %s", idx, c.dialect.Name(), got, c.literal)
+		}
+	}
+}
+
+func TestPostgresCreateTable(t *testing.T) {
+	tableInfo := &TableInfo{
+		TableName: "items",
+		Fields: []*FieldInfo{
+			{Field: "id", Type: "integer", Null: "NO", Key: "PRI", Extra: "auto_increment", Default: sql.NullString{String: "nextval('items_id_seq'::regclass)", Valid: true}},
+			{Field: "title", Type: "character varying(255)", Null: "NO"},
+			{Field: "updated", Type: "character varying(255)", Null: "YES", Default: sql.NullString{String: "NULL::character varying", Valid: true}},
+		},
+	}
+	create, after, err := postgresDialect{}.CreateTable(context.Background(), nil, tableInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `CREATE TABLE "items" (
+  "id" integer NOT NULL GENERATED BY DEFAULT AS IDENTITY,
+  "title" character varying(255) NOT NULL,
+  "updated" character varying(255) DEFAULT NULL::character varying,
+  PRIMARY KEY ("id")
+)`
+	if create != expected {
+		t.Errorf("bad create table:\n%s", create)
+	}
+	if after != `SELECT setval(pg_get_serial_sequence('"items"', 'id'), COALESCE(MAX("id"), 0) + 1, false) FROM "items";` {
+		t.Errorf("bad sequence reset: %s", after)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"unicode"
+)
+
+// Ответы {"response": ...} и ошибки {"error": ...} отдаются в JSON, XML или MessagePack - по Accept
+// (или ?format=json|xml|msgpack), по умолчанию и если ничего не подошло - в JSON.
+// XML и MessagePack строятся из того же дерева, что получилось бы из JSON: числа, строки, true/false,
+// null, объекты с ключами по алфавиту и массивы
+
+type responseEncoder interface {
+	ContentType() string
+	Encode(data interface{}) ([]byte, error)
+}
+
+var responseEncoders = map[string]responseEncoder{
+	formatJSON:    jsonEncoder{},
+	formatXML:     xmlEncoder{},
+	formatMsgPack: msgpackEncoder{},
+}
+
+// encodingWriter запоминает кодировщик, выбранный для запроса, им пользуются sendResponse и sendErrResponse
+type encodingWriter struct {
+	http.ResponseWriter
+	format  string
+	encoder responseEncoder
+}
+
+// Unwrap нужен http.ResponseController, чтобы потоковые ответы могли сбрасывать буфер
+func (w *encodingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withEncoder выбирает кодировщик ответов по запросу. Повторный вызов (MultiDbExplorer -> DbExplorer) ничего не меняет
+func withEncoder(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	if _, ok := w.(*encodingWriter); ok {
+		return w
+	}
+	format, err := negotiateFormat(r, responseFormats...)
+	if err != nil {
+		// CSV и NDJSON отдаёт сам обработчик списка, а ошибку выбора формата (400 или 406) - DbExplorer, в JSON
+		format = formatJSON
+	}
+	// один и тот же адрес отдаёт разные представления
+	w.Header().Add("Vary", "Accept")
+	return &encodingWriter{ResponseWriter: w, format: format, encoder: responseEncoders[format]}
+}
+
+func encoderOf(w http.ResponseWriter) responseEncoder {
+	if ew, ok := w.(*encodingWriter); ok {
+		return ew.encoder
+	}
+	return jsonEncoder{}
+}
+
+// formatOf - формат, в котором sendResponse отдаст ответ
+func formatOf(w http.ResponseWriter) string {
+	if ew, ok := w.(*encodingWriter); ok {
+		return ew.format
+	}
+	return formatJSON
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string {
+	return "application/json"
+}
+
+func (jsonEncoder) Encode(data interface{}) ([]byte, error) {
+	return json.MarshalIndent(data, "", "   ")
+}
+
+// plainValue переводит ответ в дерево из map[string]interface{}, []interface{}, json.Number, string, bool и nil
+// через JSON, чтобы структуры, время и NULL выглядели так же, как в JSON-ответе
+func plainValue(data interface{}) (interface{}, error) {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.UseNumber()
+	var v interface{}
+	err = dec.Decode(&v)
+	return v, err
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// xmlEncoder: корневой элемент - ключ обёртки (<response> или <error>), поля объекта - вложенные элементы,
+// элементы массива - <item>, NULL - пустой элемент с xsi:nil="true". Ключ, который не годится в имя элемента,
+// записывается как <entry key="...">
+type xmlEncoder struct{}
+
+const xsiNamespace = "http://www.w3.org/2001/XMLSchema-instance"
+
+func (xmlEncoder) ContentType() string {
+	return "application/xml; charset=utf-8"
+}
+
+func (xmlEncoder) Encode(data interface{}) ([]byte, error) {
+	v, err := plainValue(data)
+	if err != nil {
+		return nil, err
+	}
+	rootName := "response"
+	if m, ok := v.(map[string]interface{}); ok && len(m) == 1 {
+		for k, val := range m {
+			rootName, v = k, val
+		}
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(buf)
+	enc.Indent("", "   ")
+	xmlns := xml.Attr{Name: xml.Name{Local: "xmlns:xsi"}, Value: xsiNamespace}
+	err = writeXMLElement(enc, rootName, v, xmlns)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.Flush()
+	return buf.Bytes(), err
+}
+
+func writeXMLElement(enc *xml.Encoder, name string, v interface{}, attrs ...xml.Attr) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}, Attr: attrs}
+	if !isXMLName(name) {
+		start.Name.Local = "entry"
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "key"}, Value: name})
+	}
+	if v == nil {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "xsi:nil"}, Value: "true"})
+	}
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+	switch val := v.(type) {
+	case nil:
+	case map[string]interface{}:
+		for _, k := range sortedKeys(val) {
+			if err = writeXMLElement(enc, k, val[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range val {
+			if err = writeXMLElement(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	case string:
+		err = enc.EncodeToken(xml.CharData(val))
+	case json.Number:
+		err = enc.EncodeToken(xml.CharData(val.String()))
+	case bool:
+		err = enc.EncodeToken(xml.CharData(strconv.FormatBool(val)))
+	default:
+		err = fmt.Errorf("can't encode %T to xml", v)
+	}
+	if err != nil {
+		return err
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// isXMLName - имя элемента: буква или _, дальше буквы, цифры, -, _ и точка, и не начинается с xml
+func isXMLName(name string) bool {
+	if name == "" || len(name) >= 3 && (name[0]|0x20) == 'x' && (name[1]|0x20) == 'm' && (name[2]|0x20) == 'l' {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case unicode.IsLetter(c) || c == '_':
+		case i > 0 && (unicode.IsDigit(c) || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// msgpackEncoder: объекты - map, массивы - array, целые числа - int или uint наименьшего размера,
+// дробные - float64, NULL - nil
+type msgpackEncoder struct{}
+
+func (msgpackEncoder) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackEncoder) Encode(data interface{}) ([]byte, error) {
+	v, err := plainValue(data)
+	if err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, v)
+}
+
+func appendMsgpack(b []byte, v interface{}) ([]byte, error) {
+	var err error
+	switch val := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if val {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return appendMsgpackInt(b, i), nil
+		}
+		if u, err := strconv.ParseUint(val.String(), 10, 64); err == nil {
+			return binary.BigEndian.AppendUint64(append(b, 0xcf), u), nil
+		}
+		f, err := val.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f)), nil
+	case string:
+		b = appendMsgpackHeader(b, len(val), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		return append(b, val...), nil
+	case []interface{}:
+		b = appendMsgpackHeader(b, len(val), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, item := range val {
+			if b, err = appendMsgpack(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		b = appendMsgpackHeader(b, len(val), 0x80, 16, 0, 0xde, 0xdf)
+		for _, k := range sortedKeys(val) {
+			b, _ = appendMsgpack(b, k)
+			if b, err = appendMsgpack(b, val[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("can't encode %T to msgpack", v)
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 0x7f, i < 0 && i >= -32:
+		// positive и negative fixint
+		return append(b, byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(b, 0xcc, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(i))
+	case i >= 0:
+		return binary.BigEndian.AppendUint64(append(b, 0xcf), uint64(i))
+	case i >= math.MinInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+}
+
+// appendMsgpackHeader пишет заголовок строки, массива или map длины n: fix-формат, если n < fixLimit,
+// иначе формат с длиной в 1 (если есть), 2 или 4 байта
+func appendMsgpackHeader(b []byte, n int, fix byte, fixLimit int, code8, code16, code32 byte) []byte {
+	switch {
+	case n < fixLimit:
+		return append(b, fix|byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		return append(b, code8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, code16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, code32), uint32(n))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestXMLEncoder(t *testing.T) {
+	data := map[string]interface{}{
+		"response": map[string]interface{}{
+			"records": []map[string]interface{}{
+				{"id": 1, "title": "a <b> & c", "description": "", "updated": nil, "2nd": true},
+			},
+			"empty": []int{},
+		},
+	}
+	body, err := xmlEncoder{}.Encode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<response xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+   <empty></empty>
+   <records>
+      <item>
+         <entry key="2nd">true</entry>
+         <description></description>
+         <id>1</id>
+         <title>a &lt;b&gt; &amp; c</title>
+         <updated xsi:nil="true"></updated>
+      </item>
+   </records>
+</response>`
+	if string(body) != expected {
+		t.Errorf("bad xml:\n%s", body)
+	}
+
+	body, _ = xmlEncoder{}.Encode(map[string]string{"error": "unknown table"})
+	if !strings.HasSuffix(string(body), `<error xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">unknown table</error>`) {
+		t.Errorf("bad xml error:\n%s", body)
+	}
+}
+
+func TestIsXMLName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"title": true, "user_id": true, "_x": true, "a-b.c1": true, "заголовок": true,
+		"": false, "1st": false, "my col": false, "xmlns": false, "XMLdata": false, "-a": false,
+	} {
+		if isXMLName(name) != valid {
+			t.Errorf("isXMLName(%q) != %t", name, valid)
+		}
+	}
+}
+
+func TestMsgpackEncoder(t *testing.T) {
+	cases := []struct {
+		value interface{}
+		hex   string
+	}{
+		{nil, "c0"},
+		{true, "c3"},
+		{false, "c2"},
+		{0, "00"},
+		{127, "7f"},
+		{128, "cc80"},
+		{-1, "ff"},
+		{-32, "e0"},
+		{-33, "d0df"},
+		{256, "cd0100"},
+		{-129, "d1ff7f"},
+		{70000, "ce00011170"},
+		{int64(1) << 40, "cf0000010000000000"},
+		{uint64(1) << 63, "cf8000000000000000"},
+		{-(int64(1) << 40), "d3ffffff0000000000"},
+		{1.5, "cb3ff8000000000000"},
+		{"", "a0"},
+		{"abc", "a3616263"},
+		{strings.Repeat("x", 32), "d920" + strings.Repeat("78", 32)},
+		{[]int{}, "90"},
+		{[]interface{}{1, "a", nil}, "9301a161c0"},
+		{map[string]interface{}{"b": 2, "a": 1}, "82a16101a16202"},
+		{map[string]interface{}{"response": map[string]interface{}{"updated": 1}}, "81a8" + hex.EncodeToString([]byte("response")) + "81a7" + hex.EncodeToString([]byte("updated")) + "01"},
+	}
+	for idx, c := range cases {
+		body, err := msgpackEncoder{}.Encode(c.value)
+		if err != nil {
+			t.Errorf("case %d: %s", idx, err)
+			continue
+		}
+		if got := hex.EncodeToString(body); got != c.hex {
+			t.Errorf("case %d (%v): got %s, want %s", idx, c.value, got, c.hex)
+		}
+	}
+
+	long, _ := msgpackEncoder{}.Encode(make([]interface{}, 16))
+	if !bytes.HasPrefix(long, []byte{0xdc, 0x00, 0x10, 0xc0}) {
+		t.Errorf("bad array16 header %x", long[:4])
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ETag записи - хеш записи в том виде, в каком её отдаёт GET /$table/$id (после convertRow и ограничений политики).
+// Метка JSON-ответа - сам хеш, у XML и MessagePack к нему добавляется суффикс формата: сильные метки разных
+// представлений не должны совпадать. If-Match сверяется с записью без учёта суффикса, в любом формате.
+// Если обновление или удаление пришло с If-Match, текущая запись блокируется и сверяется с ним в той же транзакции,
+// что и само изменение, поэтому между проверкой и записью её никто не поменяет
+
+var (
+	errPreconditionFailed   = errors.New("precondition failed: record has been changed")
+	errPreconditionRequired = errors.New("If-Match header is required")
+)
+
+// rowETag - сильный ETag: json.Marshal сортирует ключи, так что одинаковые записи дают одинаковый хеш
+func rowETag(row map[string]interface{}) string {
+	return contentETag(row)
+}
+
+// contentETag - ETag любого ответа, который отдаём в json, например страницы списка
+func contentETag(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return bytesETag(data)
+}
+
+// formatETag - метка представления в формате format
+func formatETag(etag, format string) string {
+	if format == formatJSON || !containsString(responseFormats, format) {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + "-" + format + `"`
+}
+
+// baseETag - метка без суффикса формата, см. formatETag
+func baseETag(tag string) string {
+	for _, format := range responseFormats {
+		if trimmed := strings.TrimSuffix(tag, "-"+format+`"`); trimmed != tag {
+			return trimmed + `"`
+		}
+	}
+	return tag
+}
+
+// bytesETag - ETag уже готового тела ответа
+func bytesETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+type ifMatchKey struct{}
+
+// withIfMatch кладёт в контекст метки из If-Match. Без заголовка запрос выполняется как раньше,
+// если для таблицы If-Match не обязателен
+func (e *DbExplorer) withIfMatch(r *http.Request, table string) (*http.Request, error) {
+	header := r.Header.Values("If-Match")
+	if len(header) == 0 {
+		if e.Config.requireIfMatch(table) {
+			return r, errPreconditionRequired
+		}
+		return r, nil
+	}
+	return r.WithContext(context.WithValue(r.Context(), ifMatchKey{}, splitETags(header))), nil
+}
+
+// splitETags разбирает значения If-Match и If-None-Match: метки через запятую, возможно в нескольких заголовках
+func splitETags(header []string) []string {
+	tags := make([]string, 0, len(header))
+	for _, value := range header {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// etagMatches - сильное сравнение: слабые метки W/"..." не подходят никогда, суффикс формата не учитывается
+func etagMatches(tags []string, etag string) bool {
+	for _, tag := range tags {
+		if tag == "*" || baseETag(tag) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch блокирует запись до конца транзакции и сверяет её с If-Match.
+// Записи нет (или она не видна автору запроса) - условие тоже не выполнено
+func (e *DbExplorer) checkIfMatch(ctx context.Context, tx *writeTx, tableInfo *TableInfo, id int64) error {
+	tags, ok := ctx.Value(ifMatchKey{}).([]string)
+	if !ok {
+		return nil
+	}
+	pkName := e.Dialect.QuoteIdent(*tableInfo.findPrimKeyName())
+	args := sqlArgs{dialect: e.Dialect}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s%s",
+		pkName, e.Dialect.QuoteIdent(tableInfo.TableName), pkName, args.add(id), e.Dialect.ForUpdate())
+	var locked int64
+	err := tx.QueryRowContext(ctx, query, args.values...).Scan(&locked)
+	if err == nil {
+		var row map[string]interface{}
+		row, err = e.queryRowById(ctx, tx, e.tableView(ctx, tableInfo), id)
+		if err == nil && !etagMatches(tags, rowETag(row)) {
+			return errPreconditionFailed
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return errPreconditionFailed
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Формат ответа выбирается по ?format, а без него - по заголовку Accept среди форматов, которые умеет обработчик
+
+const (
+	formatJSON = "json"
+	// formatXML и formatMsgPack - тот же ответ, что и JSON, другим кодировщиком, см. encoding.go
+	formatXML     = "xml"
+	formatMsgPack = "msgpack"
+	formatCSV     = "csv"
+	// formatNDJSON - поток записей по одной на строку, см. ndjson.go
+	formatNDJSON = "ndjson"
+	// formatSQL - только дамп, см. dump.go
+	formatSQL = "sql"
+)
+
+var formatMediaTypes = map[string]string{
+	formatJSON:    "application/json",
+	formatXML:     "application/xml",
+	formatMsgPack: "application/msgpack",
+	formatCSV:     "text/csv",
+	formatNDJSON:  "application/x-ndjson",
+	formatSQL:     "application/sql",
+}
+
+// formatMediaTypeAliases - другие имена, под которыми клиенты просят те же форматы
+var formatMediaTypeAliases = map[string][]string{
+	formatXML:     {"text/xml"},
+	formatMsgPack: {"application/x-msgpack", "application/vnd.msgpack"},
+}
+
+var errNotAcceptable = errors.New("none of the accepted media types is supported")
+
+// listFormats - форматы GET /$table, остальные ответы {"response": ...} отдаются в responseFormats
+var (
+	listFormats     = []string{formatJSON, formatXML, formatMsgPack, formatCSV, formatNDJSON}
+	responseFormats = []string{formatJSON, formatXML, formatMsgPack}
+)
+
+// routeFormats - форматы, которые умеет отдавать маршрут. DbExplorer проверяет по ним ?format и Accept
+// до обработчика, чтобы все маршруты отвечали на неподходящий формат одинаково:
+// 400 на неизвестный или неподдерживаемый маршрутом ?format и 406, если Accept не принимает ни один из форматов
+func routeFormats(method, urlPath string) []string {
+	switch {
+	case urlPath == "/_openapi.json" || strings.HasSuffix(urlPath, "/_jsonschema"):
+		return []string{formatJSON}
+	case urlPath == "/_dump" || strings.HasSuffix(urlPath, "/_dump"):
+		return []string{formatSQL}
+	case method == http.MethodGet && strings.Count(urlPath, "/") == 1 && urlPath != "/" && !reservedRoutes[strings.TrimPrefix(urlPath, "/")]:
+		return listFormats
+	}
+	return responseFormats
+}
+
+// negotiateFormat возвращает формат из offered, первый - формат по умолчанию.
+// Ошибка с неизвестным ?format - 400, с Accept, которому ничего не подходит, - errNotAcceptable (406)
+func negotiateFormat(r *http.Request, offered ...string) (string, error) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		if !containsString(offered, f) {
+			return "", errors.New("unsupported format " + f)
+		}
+		return f, nil
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return offered[0], nil
+	}
+	best, bestQ := "", 0.0
+	for _, f := range offered {
+		for _, mediaType := range append([]string{formatMediaTypes[f]}, formatMediaTypeAliases[f]...) {
+			if q := acceptQuality(accept, mediaType); q > bestQ {
+				best, bestQ = f, q
+			}
+		}
+	}
+	if best == "" {
+		return "", errNotAcceptable
+	}
+	return best, nil
+}
+
+// acceptQuality - q, с которым Accept принимает mediaType, 0 - не принимает.
+// Точное совпадение важнее type/*, а type/* важнее */*
+func acceptQuality(accept, mediaType string) float64 {
+	mainType := mediaType[:strings.Index(mediaType, "/")]
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		rangeType := strings.ToLower(strings.TrimSpace(params[0]))
+		s := -1
+		switch rangeType {
+		case mediaType:
+			s = 2
+		case mainType + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if name == "q" {
+				q, _ = strconv.ParseFloat(value, 64)
+			}
+		}
+	}
+	return q
+}
+
+// sendFormatError отвечает на ошибку negotiateFormat
+func sendFormatError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotAcceptable) {
+		sendErrResponse(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	sendErrResponse(w, err.Error(), http.StatusBadRequest)
+}
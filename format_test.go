@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	cases := []struct {
+		query, accept string
+		want          string
+		fails         bool
+	}{
+		{"", "", formatJSON, false},
+		{"", "text/csv", formatCSV, false},
+		{"", "text/html, */*;q=0.8", formatJSON, false},
+		{"", "application/json;q=0.5, text/csv", formatCSV, false},
+		{"", "text/*", formatCSV, false},
+		// точное совпадение важнее */*, даже если у */* q выше
+		{"", "*/*, text/csv;q=0.1", formatJSON, false},
+		{"", "text/csv;q=0, application/json;q=0.2", formatJSON, false},
+		{"", "image/png", "", true},
+		{"format=csv", "application/json", formatCSV, false},
+		{"format=pdf", "", "", true},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/items?"+c.query, nil)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		got, err := negotiateFormat(r, formatJSON, formatCSV)
+		if (err != nil) != c.fails || got != c.want {
+			t.Errorf("%q %q: got %q, %v", c.query, c.accept, got, err)
+		}
+	}
+}
@@ -2,4 +2,10 @@ module db_explorer
 
 go 1.20
 
-require github.com/go-sql-driver/mysql v1.7.1
+require (
+	github.com/go-sql-driver/mysql v1.7.1
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.22
+)
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -5,13 +5,46 @@ import (
 	"net/http"
 )
 
-func sendJSONErrResponse(w http.ResponseWriter, text string, statusCode int) {
+// sendErrResponse отвечает {"error": text} в формате, выбранном по Accept
+func sendErrResponse(w http.ResponseWriter, text string, statusCode int) {
 	resp := map[string]string{"error": text}
-	js, err := json.MarshalIndent(&resp, "", "   ")
+	enc := encoderOf(w)
+	body, err := enc.Encode(resp)
 	if err != nil {
 		http.Error(w, "unknown internal server error", http.StatusInternalServerError)
 		return
 	}
+	writeEncoded(w, enc, body, statusCode)
+}
+
+// sendResponse отдаёт data (обычно {"response": ...}) в формате, выбранном по Accept
+func sendResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	encodeResponse(w, encoderOf(w), data, statusCode)
+}
+
+// encodeResponse - то же, что sendResponse, но формат выбран обработчиком
+func encodeResponse(w http.ResponseWriter, enc responseEncoder, data interface{}, statusCode int) {
+	body, err := enc.Encode(data)
+	if err != nil {
+		sendErrResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeEncoded(w, enc, body, statusCode)
+}
+
+func writeEncoded(w http.ResponseWriter, enc responseEncoder, body []byte, statusCode int) {
+	w.Header().Set("Content-Type", enc.ContentType())
+	w.WriteHeader(statusCode)
+	w.Write(body)
+}
+
+// sendJSONResponse - для документов, которые бывают только JSON: спецификация OpenAPI и JSON Schema
+func sendJSONResponse(w http.ResponseWriter, data interface{}, statusCode int) {
+	js, err := json.MarshalIndent(data, "", "   ")
+	if err != nil {
+		sendErrResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	w.Write(js)
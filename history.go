@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// История записей - журнал изменений (history.table, схема та же, что у таблицы аудита),
+// который пополняется в той же транзакции, что и само изменение.
+// Состояние записи на момент T: after последнего изменения не позже T, а если изменений до T не было -
+// before первого изменения после T. Если изменений нет совсем, запись с тех пор не менялась
+
+var errNoRecordAsOf = errors.New("record not found")
+
+// recordAsOf восстанавливает запись на момент asOf, для несуществовавшей в тот момент записи - errNoRecordAsOf
+func (e *DbExplorer) recordAsOf(r *http.Request, tableInfo *TableInfo, id int64, asOf time.Time) (map[string]interface{}, error) {
+	ctx := r.Context()
+	// время в журнале с точностью до микросекунды, поэтому "не позже asOf" - это "раньше asOf + 1мкс"
+	bound := asOf.Add(time.Microsecond)
+	f := auditFilter{Table: tableInfo.TableName, ID: &id, Limit: 1}
+
+	f.Until = bound
+	changes, err := e.queryChangeLog(ctx, e.Config.History.Table, f, true)
+	if err != nil {
+		return nil, err
+	}
+	var row map[string]interface{}
+	switch {
+	case len(changes) > 0:
+		row = changes[0].After
+	default:
+		f.Until, f.Since = time.Time{}, bound
+		changes, err = e.queryChangeLog(ctx, e.Config.History.Table, f, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) > 0 {
+			row = changes[0].Before
+		} else {
+			row, err = e.getRowFromTableById(ctx, tableInfo, id)
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, errNoRecordAsOf
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if row == nil || !e.rowVisible(r, tableInfo, row) {
+		return nil, errNoRecordAsOf
+	}
+	return e.restrictRow(tableInfo, row), nil
+}
+
+// rowVisible - восстановленная из журнала запись тоже должна подходить под построчную политику
+func (e *DbExplorer) rowVisible(r *http.Request, tableInfo *TableInfo, row map[string]interface{}) bool {
+	conds, err := e.rowConditions(r.Context(), tableInfo)
+	if err != nil {
+		return false
+	}
+	for _, c := range conds {
+		if !sameRowValue(row[c.Column], c.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// restrictRow оставляет в записи из журнала только те поля, которые сейчас есть в tableInfo
+func (e *DbExplorer) restrictRow(tableInfo *TableInfo, row map[string]interface{}) map[string]interface{} {
+	if row == nil {
+		return nil
+	}
+	restricted := make(map[string]interface{}, len(row))
+	for _, fldInfo := range tableInfo.Fields {
+		if v, ok := row[fldInfo.Field]; ok && !fldInfo.WriteOnly {
+			restricted[fldInfo.Field] = v
+		}
+	}
+	return restricted
+}
+
+// asOfError - ошибка в параметре as_of или история для таблицы не ведётся
+type asOfError struct {
+	text   string
+	status int
+}
+
+func (ae *asOfError) Error() string {
+	return ae.text
+}
+
+func (e *DbExplorer) handleAsOf(r *http.Request, tableInfo *TableInfo, id int64) (map[string]interface{}, error) {
+	if !e.Config.History.enabledFor(tableInfo.TableName) {
+		return nil, &asOfError{text: "history is disabled for this table", status: http.StatusNotFound}
+	}
+	asOf, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("as_of"))
+	if err != nil {
+		return nil, &asOfError{text: "bad as_of value, RFC 3339 expected", status: http.StatusBadRequest}
+	}
+	return e.recordAsOf(r, tableInfo, id, asOf.UTC())
+}
+
+func (e *DbExplorer) handlerRecordHistory(tableName string, queryId string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tableInfo, exists := e.tableInfoFor(r, tableName)
+		if !exists {
+			sendErrResponse(w, "unknown table", http.StatusNotFound)
+			return
+		}
+		if !e.Config.History.enabledFor(tableName) {
+			sendErrResponse(w, "history is disabled for this table", http.StatusNotFound)
+			return
+		}
+		id, err := strconv.ParseInt(queryId, 10, 64)
+		if err != nil {
+			sendErrResponse(w, "bad id value", http.StatusBadRequest)
+			return
+		}
+		page := e.Config.pagination(tableName)
+		page.AllowUnbounded = false
+		limit, offset, err := parsePage(r.URL.Query(), page)
+		if err != nil {
+			sendErrResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f := auditFilter{Table: tableName, ID: &id, Limit: limit, Offset: offset}
+		changes, err := e.queryChangeLog(r.Context(), e.Config.History.Table, f, false)
+		if err != nil {
+			e.logRequest(r, err)
+			sendErrResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		history := make([]map[string]interface{}, 0, len(changes))
+		for _, ch := range changes {
+			if (ch.Before != nil && !e.rowVisible(r, tableInfo, ch.Before)) || (ch.After != nil && !e.rowVisible(r, tableInfo, ch.After)) {
+				continue
+			}
+			history = append(history, map[string]interface{}{
+				"time":      ch.Time,
+				"principal": ch.Principal,
+				"operation": ch.Operation,
+				"before":    e.restrictRow(tableInfo, ch.Before),
+				"after":     e.restrictRow(tableInfo, ch.After),
+			})
+		}
+		sendResponse(w, map[string]interface{}{"response": map[string]interface{}{"history": history}}, http.StatusOK)
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// POST /$table/_import - массовая вставка из CSV (Content-Type: text/csv, первая строка - имена колонок)
+// или NDJSON (application/x-ndjson, по объекту на строку). Каждая строка проверяется так же, как тело PUT /$table,
+// и вставляется транзакциями по import.chunk_size строк.
+// ?on_error=abort (по умолчанию) останавливает импорт на первой ошибке: текущая порция откатывается,
+// уже закоммиченные остаются. ?on_error=skip пропускает плохие строки и продолжает.
+// В ответе - сколько строк вставлено, сколько не вставлено, сколько откачено вместе с порцией,
+// какие колонки файла пропущены (первичный ключ назначает база) и ошибки с номерами строк файла
+
+// importMaxErrors - сколько ошибок перечислять в ответе, остальные только считаются
+const importMaxErrors = 100
+
+// errImportFatal - файл дальше читать нельзя (сломан CSV), импорт прерывается при любом on_error
+var errImportFatal = errors.New("can't continue import")
+
+// importSource отдаёт записи файла по одной, в конце - io.EOF
+type importSource interface {
+	next() (line int, record map[string]interface{}, err error)
+}
+
+type csvImportSource struct {
+	reader  *csv.Reader
+	columns []*FieldInfo
+	null    *string
+}
+
+// newCSVImportSource - null - строка, которая читается как NULL, nil - такой нет и пустое поле остаётся пустой строкой
+func newCSVImportSource(body io.Reader, tableInfo *TableInfo, null *string) (*csvImportSource, error) {
+	reader := csv.NewReader(body)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("can't read csv header: %w", err)
+	}
+	src := &csvImportSource{reader: reader, null: null, columns: make([]*FieldInfo, 0, len(header))}
+	for _, name := range header {
+		fldInfo := tableInfo.getFieldInfoByName(strings.TrimSpace(name))
+		if fldInfo == nil {
+			return nil, fmt.Errorf("unknown column %s", name)
+		}
+		src.columns = append(src.columns, fldInfo)
+	}
+	return src, nil
+}
+
+func (s *csvImportSource) next() (int, map[string]interface{}, error) {
+	values, err := s.reader.Read()
+	if err == io.EOF {
+		return 0, nil, err
+	}
+	line, _ := s.reader.FieldPos(0)
+	if errors.Is(err, csv.ErrFieldCount) {
+		return line, nil, fmt.Errorf("expected %d fields, got %d", len(s.columns), len(values))
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			line = parseErr.StartLine
+		}
+		return line, nil, fmt.Errorf("%w: %s", errImportFatal, err)
+	}
+	record := make(map[string]interface{}, len(values))
+	for i, fldInfo := range s.columns {
+		record[fldInfo.Field] = csvImportValue(values[i], fldInfo, s.null)
+	}
+	return line, record, nil
+}
+
+// csvImportValue приводит значение из CSV к тому, что пришло бы в JSON: числа - float64, true/false - bool, null - nil.
+// Не разобравшееся число остаётся строкой, и его отвергнет normalize
+func csvImportValue(value string, fldInfo *FieldInfo, null *string) interface{} {
+	if null != nil && value == *null {
+		return nil
+	}
+	switch fldInfo.columnType().Kind {
+	case kindInteger, kindFloat:
+		if num, err := strconv.ParseFloat(value, 64); err == nil {
+			return num
+		}
+	case kindBool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+type ndjsonImportSource struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONImportSource(body io.Reader) *ndjsonImportSource {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &ndjsonImportSource{scanner: scanner}
+}
+
+func (s *ndjsonImportSource) next() (int, map[string]interface{}, error) {
+	for s.scanner.Scan() {
+		s.line++
+		data := strings.TrimSpace(s.scanner.Text())
+		if data == "" {
+			continue
+		}
+		record := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(data), &record); err != nil {
+			return s.line, nil, err
+		}
+		return s.line, record, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return s.line + 1, nil, fmt.Errorf("%w: %s", errImportFatal, err)
+	}
+	return 0, nil, io.EOF
+}
+
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// importSummary - RolledBack - строки без ошибок, откаченные вместе с порцией при остановке импорта,
+// IgnoredColumns - колонки первичного ключа, которые были в файле, но не вставлялись
+type importSummary struct {
+	Inserted       int           `json:"inserted"`
+	Failed         int           `json:"failed"`
+	RolledBack     int           `json:"rolled_back"`
+	Aborted        bool          `json:"aborted"`
+	IgnoredColumns []string      `json:"ignored_columns"`
+	Errors         []importError `json:"errors"`
+}
+
+func (s *importSummary) fail(line int, err error) {
+	s.Failed++
+	if len(s.Errors) < importMaxErrors {
+		s.Errors = append(s.Errors, importError{Line: line, Error: err.Error()})
+	}
+}
+
+// ignorePrimaryKey запоминает колонки первичного ключа из записи: buildInsert их не вставляет
+func (s *importSummary) ignorePrimaryKey(tableInfo *TableInfo, record map[string]interface{}) {
+	for _, fldInfo := range tableInfo.Fields {
+		if _, exists := record[fldInfo.Field]; exists && fldInfo.Key == "PRI" && !containsString(s.IgnoredColumns, fldInfo.Field) {
+			s.IgnoredColumns = append(s.IgnoredColumns, fldInfo.Field)
+		}
+	}
+}
+
+// checkImportColumns - тело импорта не разбирается при проверке политики, поэтому колонки сверяются здесь
+func (e *DbExplorer) checkImportColumns(ctx context.Context, tableInfo *TableInfo, record map[string]interface{}) error {
+	decision, ok := accessFromContext(ctx)
+	if !ok || decision.Columns == nil {
+		return nil
+	}
+	for _, fldInfo := range tableInfo.Fields {
+		if _, exists := record[fldInfo.Field]; exists && !decision.columnAllowed(fldInfo) {
+			return fmt.Errorf("access denied: column %s of %s is not writable", fldInfo.Field, tableInfo.TableName)
+		}
+	}
+	return nil
+}
+
+// importRow вставляет одну запись. При skip строка выполняется в savepoint, чтобы её ошибка
+// не испортила остальную порцию (в PostgreSQL ошибка иначе прерывает всю транзакцию)
+func (e *DbExplorer) importRow(ctx context.Context, tx *writeTx, tableInfo *TableInfo, record map[string]interface{}, skip bool) error {
+	err := e.checkImportColumns(ctx, tableInfo, record)
+	if err != nil {
+		return err
+	}
+	query, args, err := e.buildInsert(ctx, tableInfo, record)
+	if err != nil {
+		return err
+	}
+	if !skip {
+		_, err = e.insertRow(ctx, tx, tableInfo, query, args)
+		return err
+	}
+	changes, touched := len(tx.changes), len(tx.touched)
+	if _, err = tx.ExecContext(ctx, "SAVEPOINT import_row"); err != nil {
+		return err
+	}
+	_, err = e.insertRow(ctx, tx, tableInfo, query, args)
+	if err != nil {
+		tx.changes, tx.touched = tx.changes[:changes], tx.touched[:touched]
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row"); rbErr != nil {
+			return fmt.Errorf("%w: %s", errImportFatal, rbErr)
+		}
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT import_row")
+	return err
+}
+
+var errImportAborted = errors.New("import aborted")
+
+func (e *DbExplorer) importRows(ctx context.Context, tableInfo *TableInfo, src importSource, skip bool) *importSummary {
+	summary := &importSummary{IgnoredColumns: make([]string, 0), Errors: make([]importError, 0)}
+	done := false
+	for !done {
+		inserted, lastLine := 0, 0
+		err := e.inTx(ctx, func(tx *writeTx) error {
+			for inserted < e.Config.Import.ChunkSize {
+				line, record, err := src.next()
+				if err == io.EOF {
+					done = true
+					return nil
+				}
+				if err == nil {
+					summary.ignorePrimaryKey(tableInfo, record)
+					err = e.importRow(ctx, tx, tableInfo, record, skip)
+				}
+				if err != nil {
+					summary.fail(line, err)
+					if !skip || errors.Is(err, errImportFatal) || ctx.Err() != nil {
+						return errImportAborted
+					}
+					continue
+				}
+				inserted++
+				lastLine = line
+			}
+			return nil
+		})
+		if errors.Is(err, errImportAborted) {
+			summary.RolledBack += inserted
+			summary.Aborted = true
+			return summary
+		}
+		if err != nil {
+			// не закоммитилась вся порция
+			summary.RolledBack += inserted
+			summary.Errors = append(summary.Errors, importError{Line: lastLine, Error: err.Error()})
+			summary.Aborted = true
+			return summary
+		}
+		summary.Inserted += inserted
+	}
+	return summary
+}
+
+func (e *DbExplorer) handlerImport(tableName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tableInfo, exists := e.tableInfo(tableName)
+		if !exists {
+			sendErrResponse(w, "unknown table", http.StatusNotFound)
+			return
+		}
+		skip := false
+		switch r.URL.Query().Get("on_error") {
+		case "", "abort":
+		case "skip":
+			skip = true
+		default:
+			sendErrResponse(w, "bad on_error value, abort or skip expected", http.StatusBadRequest)
+			return
+		}
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		var src importSource
+		switch mediaType {
+		case "text/csv":
+			// пустое поле - пустая строка, NULL читается только из явно заданной строки:
+			// ?null=... (в том числе пустой) или непустого csv.null
+			var null *string
+			if r.URL.Query().Has("null") {
+				value := r.URL.Query().Get("null")
+				null = &value
+			} else if e.Config.CSV.Null != "" {
+				null = &e.Config.CSV.Null
+			}
+			csvSrc, err := newCSVImportSource(r.Body, tableInfo, null)
+			if err != nil {
+				sendErrResponse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			src = csvSrc
+		case "application/x-ndjson":
+			src = newNDJSONImportSource(r.Body)
+		default:
+			sendErrResponse(w, "unsupported content type, text/csv or application/x-ndjson expected", http.StatusUnsupportedMediaType)
+			return
+		}
+		summary := e.importRows(r.Context(), tableInfo, src, skip)
+		e.logRequest(r, "import into", tableName, "inserted", summary.Inserted, "failed", summary.Failed, "rolled back", summary.RolledBack)
+		sendResponse(w, map[string]interface{}{"response": summary}, http.StatusOK)
+	}
+}
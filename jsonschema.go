@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+)
+
+// схемы для клиентской валидации строятся по тому же columnType, которым проверяются входящие данные,
+// поэтому клиент и сервер отвергают одно и то же. Неизвестные поля сервер пропускает, а схема, как и OpenAPI,
+// их не допускает: передавать их незачем
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+func jsonSchemaField(fldInfo *FieldInfo) map[string]interface{} {
+	ct := fldInfo.columnType()
+	schema := ct.jsonSchema()
+	if ct.Nullable {
+		schema["type"] = []interface{}{schema["type"], "null"}
+		if enum, ok := schema["enum"].([]string); ok {
+			values := make([]interface{}, 0, len(enum)+1)
+			for _, v := range enum {
+				values = append(values, v)
+			}
+			schema["enum"] = append(values, nil)
+		}
+	}
+	if fldInfo.Comment != "" {
+		schema["description"] = fldInfo.Comment
+	}
+	return schema
+}
+
+// jsonSchemaCreate - тело PUT /$table: первичный ключ при вставке игнорируется, read only поля задавать нельзя,
+// пропущенные поля получают значение по умолчанию, поэтому обязательных полей нет
+func jsonSchemaCreate(tableInfo *TableInfo) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for _, fldInfo := range tableInfo.Fields {
+		if fldInfo.Key == "PRI" {
+			continue
+		}
+		if fldInfo.ReadOnly {
+			properties[fldInfo.Field] = false
+			continue
+		}
+		properties[fldInfo.Field] = jsonSchemaField(fldInfo)
+	}
+	return map[string]interface{}{
+		"title":                "create " + tableInfo.TableName,
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// jsonSchemaUpdate - тело POST /$table/$id: первичный ключ и read only поля обновлять нельзя
+func jsonSchemaUpdate(tableInfo *TableInfo) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for _, fldInfo := range tableInfo.Fields {
+		if fldInfo.Key == "PRI" || fldInfo.ReadOnly {
+			properties[fldInfo.Field] = false
+			continue
+		}
+		properties[fldInfo.Field] = jsonSchemaField(fldInfo)
+	}
+	return map[string]interface{}{
+		"title":                "update " + tableInfo.TableName,
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// handlerJSONSchema отдаёт обе схемы в $defs, клиент может сослаться на /$table/_jsonschema#/$defs/create.
+// С параметром payload=create или payload=update отдаётся только одна схема
+func (e *DbExplorer) handlerJSONSchema(tableName string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// закрытых политикой колонок нет и в схеме
+		tableInfo, exists := e.tableInfoFor(r, tableName)
+		if !exists {
+			sendErrResponse(w, "unknown table", http.StatusNotFound)
+			return
+		}
+		var schema map[string]interface{}
+		payload := r.URL.Query().Get("payload")
+		switch payload {
+		case "":
+			schema = map[string]interface{}{
+				"title": tableName,
+				"$defs": map[string]interface{}{
+					"create": jsonSchemaCreate(tableInfo),
+					"update": jsonSchemaUpdate(tableInfo),
+				},
+			}
+		case "create":
+			schema = jsonSchemaCreate(tableInfo)
+		case "update":
+			schema = jsonSchemaUpdate(tableInfo)
+		default:
+			sendErrResponse(w, "unknown payload", http.StatusBadRequest)
+			return
+		}
+		schema["$schema"] = jsonSchemaDialect
+		// MultiDbExplorer отрезал от пути /db/$name, а $id должен указывать на адрес, по которому схему запросили
+		schema["$id"] = e.basePath() + r.URL.RequestURI()
+		sendJSONResponse(w, schema, http.StatusOK)
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// Проверяются только подписи HS256, RS256 и ES256, alg=none и всё остальное отклоняется.
+// Ключи берутся из конфига: общий секрет, PEM с открытым ключом и/или локальный JWKS
+
+type jwtKey struct {
+	kid string
+	alg string
+	// []byte для HS256, *rsa.PublicKey для RS256, *ecdsa.PublicKey для ES256
+	key interface{}
+}
+
+type jwtVerifier struct {
+	keys        []jwtKey
+	issuer      string
+	audience    string
+	scopesClaim string
+	now         func() time.Time
+}
+
+func newJWTVerifier(cfg JWTConfig) (*jwtVerifier, error) {
+	v := &jwtVerifier{
+		issuer:      cfg.Issuer,
+		audience:    cfg.Audience,
+		scopesClaim: cfg.ScopesClaim,
+		now:         time.Now,
+	}
+	if v.scopesClaim == "" {
+		v.scopesClaim = "scope"
+	}
+	if cfg.Secret != "" {
+		v.keys = append(v.keys, jwtKey{alg: "HS256", key: []byte(cfg.Secret)})
+	}
+	if cfg.PublicKeyFile != "" {
+		key, err := readPublicKeyFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, key)
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := readJWKSFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, keys...)
+	}
+	if len(v.keys) == 0 {
+		return nil, errors.New("jwt: no keys configured")
+	}
+	return v, nil
+}
+
+func readPublicKeyFile(path string) (jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return jwtKey{}, fmt.Errorf("jwt: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return jwtKey{}, fmt.Errorf("jwt: %s is not a PEM file", path)
+	}
+	var pub interface{}
+	if block.Type == "RSA PUBLIC KEY" {
+		pub, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	} else {
+		pub, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return jwtKey{}, fmt.Errorf("jwt: %s: %w", path, err)
+	}
+	return publicJWTKey("", pub)
+}
+
+func publicJWTKey(kid string, pub interface{}) (jwtKey, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return jwtKey{kid: kid, alg: "RS256", key: k}, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return jwtKey{}, errors.New("jwt: only P-256 curve is supported for ES256")
+		}
+		return jwtKey{kid: kid, alg: "ES256", key: k}, nil
+	}
+	return jwtKey{}, fmt.Errorf("jwt: unsupported public key type %T", pub)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+// readJWKSFile читает ключи RSA, EC P-256 и oct, ключи для шифрования (use=enc) пропускаются
+func readJWKSFile(path string) ([]jwtKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: %w", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: %s: %w", path, err)
+	}
+	keys := make([]jwtKey, 0, len(set.Keys))
+	for i, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		key, err := k.jwtKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwt: %s: keys[%d]: %w", path, i, err)
+		}
+		if k.Alg != "" && k.Alg != key.alg {
+			return nil, fmt.Errorf("jwt: %s: keys[%d]: alg %s is not supported", path, i, k.Alg)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (k jwk) jwtKey() (jwtKey, error) {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, errN := b64.DecodeString(k.N)
+		e, errE := b64.DecodeString(k.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 {
+			return jwtKey{}, errors.New("bad RSA key")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return publicJWTKey(k.Kid, pub)
+	case "EC":
+		if k.Crv != "P-256" {
+			return jwtKey{}, fmt.Errorf("curve %s is not supported", k.Crv)
+		}
+		x, errX := b64.DecodeString(k.X)
+		y, errY := b64.DecodeString(k.Y)
+		if errX != nil || errY != nil {
+			return jwtKey{}, errors.New("bad EC key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return jwtKey{}, errors.New("bad EC key")
+		}
+		return publicJWTKey(k.Kid, pub)
+	case "oct":
+		secret, err := b64.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return jwtKey{}, errors.New("bad oct key")
+		}
+		return jwtKey{kid: k.Kid, alg: "HS256", key: secret}, nil
+	}
+	return jwtKey{}, fmt.Errorf("key type %q is not supported", k.Kty)
+}
+
+// looksLikeJWT отличает токен от API-ключа: у JWT ровно три части через точку
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// verify проверяет подпись и сроки токена и возвращает его claims.
+// Числа в claims остаются json.Number, чтобы не терять точность идентификаторов
+func (v *jwtVerifier) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return nil, errors.New("malformed token header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+	for _, key := range v.keys {
+		if key.alg != header.Alg || (header.Kid != "" && key.kid != "" && key.kid != header.Kid) {
+			continue
+		}
+		if verifyJWTSignature(key, signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("bad token signature")
+	}
+
+	claims := make(map[string]interface{})
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	now := v.now()
+	if exp, ok := numericClaim(claims, "exp"); ok && !now.Before(time.Unix(exp, 0)) {
+		return nil, errors.New("token expired")
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Before(time.Unix(nbf, 0)) {
+		return nil, errors.New("token is not valid yet")
+	}
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return nil, errors.New("bad token issuer")
+	}
+	if v.audience != "" && !containsString(stringsClaim(claims, "aud"), v.audience) {
+		return nil, errors.New("bad token audience")
+	}
+	return claims, nil
+}
+
+func verifyJWTSignature(key jwtKey, signed, sig []byte) bool {
+	switch k := key.key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig) == nil
+	case *ecdsa.PublicKey:
+		// в JWT подпись ES256 - это r и s по 32 байта подряд, а не ASN.1
+		if len(sig) != 64 {
+			return false
+		}
+		hash := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(k, hash[:], r, s)
+	}
+	return false
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+func numericClaim(claims map[string]interface{}, name string) (int64, bool) {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// stringsClaim понимает и строку через пробел (scope), и массив строк (scp, aud)
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return []string{}
+}
+
+// principal строит Principal из проверенного токена, в лог попадает sub
+func (v *jwtVerifier) principal(claims map[string]interface{}) *Principal {
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		sub = "unknown"
+	}
+	return &Principal{
+		Label:  sub,
+		Claims: claims,
+		Scopes: stringsClaim(claims, v.scopesClaim),
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signTestJWT подписывает claims ключом: []byte - HS256, *rsa.PrivateKey - RS256, *ecdsa.PrivateKey - ES256
+func signTestJWT(t *testing.T, key interface{}, kid string, claims map[string]interface{}) string {
+	var alg string
+	switch key.(type) {
+	case []byte:
+		alg = "HS256"
+	case *rsa.PrivateKey:
+		alg = "RS256"
+	case *ecdsa.PrivateKey:
+		alg = "ES256"
+	}
+	header := map[string]interface{}{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	hash := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTVerify(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemPath := filepath.Join(dir, "rsa.pem")
+	err = os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := base64.RawURLEncoding
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []interface{}{
+		map[string]interface{}{
+			"kty": "EC", "kid": "ec-1", "crv": "P-256",
+			"x": b64.EncodeToString(ecKey.PublicKey.X.FillBytes(make([]byte, 32))),
+			"y": b64.EncodeToString(ecKey.PublicKey.Y.FillBytes(make([]byte, 32))),
+		},
+	}})
+	jwksPath := filepath.Join(dir, "jwks.json")
+	err = os.WriteFile(jwksPath, jwks, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := newJWTVerifier(JWTConfig{
+		Secret:        "secret",
+		PublicKeyFile: pemPath,
+		JWKSFile:      jwksPath,
+		Issuer:        "idp",
+		Audience:      "db_explorer",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	v.now = func() time.Time { return now }
+
+	claims := func(extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "alice", "iss": "idp", "aud": []string{"db_explorer"},
+			"exp": now.Add(time.Hour).Unix(), "scope": "items:read users:write",
+		}
+		for k, val := range extra {
+			c[k] = val
+		}
+		return c
+	}
+	otherEC, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	cases := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"hs256", signTestJWT(t, []byte("secret"), "", claims(nil)), ""},
+		{"rs256", signTestJWT(t, rsaKey, "", claims(nil)), ""},
+		{"es256 by kid", signTestJWT(t, ecKey, "ec-1", claims(nil)), ""},
+		{"wrong secret", signTestJWT(t, []byte("other"), "", claims(nil)), "bad token signature"},
+		{"unknown ec key", signTestJWT(t, otherEC, "ec-1", claims(nil)), "bad token signature"},
+		{"expired", signTestJWT(t, []byte("secret"), "", claims(map[string]interface{}{"exp": now.Unix()})), "token expired"},
+		{"not yet", signTestJWT(t, []byte("secret"), "", claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})), "not valid yet"},
+		{"issuer", signTestJWT(t, []byte("secret"), "", claims(map[string]interface{}{"iss": "evil"})), "bad token issuer"},
+		{"audience", signTestJWT(t, []byte("secret"), "", claims(map[string]interface{}{"aud": "other"})), "bad token audience"},
+		{"alg none", b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + b64.EncodeToString([]byte(`{"sub":"alice"}`)) + ".", "bad token signature"},
+		{"garbage", "a.b.c", "malformed"},
+	}
+	for _, c := range cases {
+		got, err := v.verify(c.token)
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", c.name, err)
+				continue
+			}
+			p := v.principal(got)
+			if p.Label != "alice" || !p.hasScope("items:read") || p.hasScope("items:write") {
+				t.Errorf("%s: bad principal %+v", c.name, p)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error %q, got %v", c.name, c.err, err)
+		}
+	}
+}
+
+func TestRouteScope(t *testing.T) {
+	cases := map[string]string{
+		"GET /":                  "",
+		"GET /_openapi.json":     "",
+		"POST /_admin/reload":    "admin",
+		"GET /items":             "items:read",
+		"GET /items/1":           "items:read",
+		"GET /items/_jsonschema": "items:read",
+		"PUT /items":             "items:write",
+		"POST /items/1":          "items:write",
+		"DELETE /users/1":        "users:write",
+	}
+	for route, want := range cases {
+		method, path, _ := strings.Cut(route, " ")
+		if got := routeScope(method, path); got != want {
+			t.Errorf("%s: got %q, want %q", route, got, want)
+		}
+	}
+	p := &Principal{Label: "bob", Claims: map[string]interface{}{}, Scopes: []string{"*:read"}}
+	if !p.hasScope("users:read") || p.hasScope("users:write") {
+		t.Errorf("bad wildcard scopes: %v", p.Scopes)
+	}
+}
@@ -3,32 +3,121 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"net/http"
+	"os"
+	"syscall"
+	"time"
 )
 
-var (
-	// DSN это соединение с базой
-	// вы можете изменить этот на тот который вам нужен
-	// docker run -p 3306:3306 -v $(PWD):/docker-entrypoint-initdb.d -e MYSQL_ROOT_PASSWORD=1234 -e MYSQL_DATABASE=golang -d mysql
-	// DSN = "root@tcp(localhost:3306)/golang2017?charset=utf8"
-	DSN = "root:1234@tcp(localhost:3306)/golang?charset=utf8"
-)
+// Соединение с базой, адрес и всё остальное задаются флагами, переменными DB_EXPLORER_* или файлом,
+// см. config.go и config.example.yaml. Например:
+// docker run -p 3306:3306 -v $(PWD):/docker-entrypoint-initdb.d -e MYSQL_ROOT_PASSWORD=1234 -e MYSQL_DATABASE=golang -d mysql
+// go run . -dsn "root:1234@tcp(localhost:3306)/golang?charset=utf8"
 
-func main() {
-	db, err := sql.Open("mysql", DSN)
+type explorerHandler interface {
+	http.Handler
+	ReloadOnSignal(ctx context.Context, sig ...os.Signal)
+	WatchSchema(ctx context.Context, interval time.Duration)
+}
+
+func openDB(cfg *Config, driver, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.Pool.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Pool.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.Pool.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.Pool.ConnMaxIdleTime)
 	err = db.Ping() // вот тут будет первое подключение к базе
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	return db, nil
+}
+
+func newHandler(cfg *Config) (explorerHandler, error) {
+	if len(cfg.Databases) == 0 {
+		db, err := openDB(cfg, cfg.Driver, cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		dialect, err := DialectByDriver(cfg.Driver)
+		if err != nil {
+			return nil, err
+		}
+		explorer, err := NewDbExplorerWithConfig(db, dialect, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.ReadOnlyDSN != "" {
+			explorer.ReadDb, err = openDB(cfg, cfg.Driver, cfg.ReadOnlyDSN)
+			if err != nil {
+				return nil, fmt.Errorf("read only dsn: %w", err)
+			}
+		}
+		return explorer, nil
+	}
+	dbs := make([]NamedDB, 0, len(cfg.Databases))
+	for _, d := range cfg.Databases {
+		db, err := openDB(cfg, d.Driver, d.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("database %s: %w", d.Name, err)
+		}
+		dialect, err := DialectByDriver(d.Driver)
+		if err != nil {
+			return nil, fmt.Errorf("database %s: %w", d.Name, err)
+		}
+		namedDb := NamedDB{Name: d.Name, Db: db, Dialect: dialect}
+		if d.ReadOnlyDSN != "" {
+			namedDb.ReadDb, err = openDB(cfg, d.Driver, d.ReadOnlyDSN)
+			if err != nil {
+				return nil, fmt.Errorf("database %s: read only dsn: %w", d.Name, err)
+			}
+		}
+		dbs = append(dbs, namedDb)
 	}
+	return NewMultiDbExplorerWithConfig(dbs, cfg)
+}
 
-	handler, err := NewDbExplorer(db)
+func main() {
+	cfg, err := LoadConfig(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bad config:", err)
+		os.Exit(2)
+	}
+
+	handler, err := newHandler(cfg)
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Println("starting server at :8082")
-	http.ListenAndServe(":8082", handler)
+	ctx := context.Background()
+	go handler.ReloadOnSignal(ctx, syscall.SIGHUP)
+	if cfg.SchemaPollInterval > 0 {
+		go handler.WatchSchema(ctx, cfg.SchemaPollInterval)
+	}
+
+	server := &http.Server{
+		Addr:         cfg.Listen,
+		Handler:      handler,
+		ReadTimeout:  cfg.Timeouts.Read,
+		WriteTimeout: cfg.Timeouts.Write,
+		IdleTimeout:  cfg.Timeouts.Idle,
+	}
+	fmt.Println("starting server at", cfg.Listen)
+	if cfg.TLS.CertFile != "" {
+		err = server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		panic(err)
+	}
 }
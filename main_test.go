@@ -1,19 +1,29 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"database/sql"
 	"fmt"
 	"reflect"
+	"sort"
 	"testing"
 
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 // CaseResponse
@@ -26,14 +36,17 @@ type Case struct {
 	Status int
 	Result interface{}
 	Body   interface{}
+	// Headers - дополнительные заголовки запроса
+	Headers map[string]string
 }
 
 var (
 	client = &http.Client{Timeout: time.Second}
 )
 
-func PrepareTestApis(db *sql.DB) {
-	qs := []string{
+// testSchemas - одни и те же тестовые таблицы для каждого поддерживаемого диалекта
+var testSchemas = map[string][]string{
+	"mysql": {
 		`DROP TABLE IF EXISTS items;`,
 
 		`CREATE TABLE items (
@@ -62,7 +75,101 @@ func PrepareTestApis(db *sql.DB) {
 
 		`INSERT INTO users (user_id, login, password, email, info, updated) VALUES
 (1,	'rvasily',	'love',	'rvasily@example.com',	'none',	NULL);`,
+	},
+	"sqlite": {
+		`DROP TABLE IF EXISTS items;`,
+
+		`CREATE TABLE items (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title varchar(255) NOT NULL,
+  description text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`,
+
+		`INSERT INTO items (id, title, description, updated) VALUES
+(1,	'database/sql',	'Рассказать про базы данных',	'rvasily'),
+(2,	'memcache',	'Рассказать про мемкеш с примером использования',	NULL);`,
+
+		`DROP TABLE IF EXISTS users;`,
+
+		`CREATE TABLE users (
+  user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  login varchar(255) NOT NULL,
+  password varchar(255) NOT NULL,
+  email varchar(255) NOT NULL,
+  info text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`,
+
+		`INSERT INTO users (user_id, login, password, email, info, updated) VALUES
+(1,	'rvasily',	'love',	'rvasily@example.com',	'none',	NULL);`,
+	},
+	"postgres": {
+		`DROP TABLE IF EXISTS items;`,
+
+		`CREATE TABLE items (
+  id serial PRIMARY KEY,
+  title varchar(255) NOT NULL,
+  description text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`,
+
+		`INSERT INTO items (id, title, description, updated) VALUES
+(1,	'database/sql',	'Рассказать про базы данных',	'rvasily'),
+(2,	'memcache',	'Рассказать про мемкеш с примером использования',	NULL);`,
+
+		`SELECT setval('items_id_seq', 2);`,
+
+		`DROP TABLE IF EXISTS users;`,
+
+		`CREATE TABLE users (
+  user_id serial PRIMARY KEY,
+  login varchar(255) NOT NULL,
+  password varchar(255) NOT NULL,
+  email varchar(255) NOT NULL,
+  info text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`,
+
+		`INSERT INTO users (user_id, login, password, email, info, updated) VALUES
+(1,	'rvasily',	'love',	'rvasily@example.com',	'none',	NULL);`,
+
+		`SELECT setval('users_user_id_seq', 1);`,
+	},
+}
+
+// openTestDB открывает базу, на которой гоняются тесты.
+// По умолчанию это sqlite в памяти, чтобы тесты не требовали внешних сервисов.
+// Другую базу можно задать через DB_EXPLORER_TEST_DRIVER и DB_EXPLORER_TEST_DSN, например
+// DB_EXPLORER_TEST_DRIVER=mysql go test
+func openTestDB() *sql.DB {
+	driver := os.Getenv("DB_EXPLORER_TEST_DRIVER")
+	dsn := os.Getenv("DB_EXPLORER_TEST_DSN")
+	switch {
+	case driver == "":
+		driver = "sqlite3"
+		dsn = "file:db_explorer_test?mode=memory&cache=shared"
+	case driver == "mysql" && dsn == "":
+		// docker run -p 3306:3306 -v $(PWD):/docker-entrypoint-initdb.d -e MYSQL_ROOT_PASSWORD=1234 -e MYSQL_DATABASE=golang -d mysql
+		dsn = "root:1234@tcp(localhost:3306)/golang?charset=utf8"
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		panic(err)
+	}
+	err = db.Ping()
+	if err != nil {
+		panic(err)
+	}
+	return db
+}
+
+func PrepareTestApis(db *sql.DB) {
+	dialect, err := detectDialect(db)
+	if err != nil {
+		panic(err)
 	}
+	qs := testSchemas[dialect.Name()]
 
 	for _, q := range qs {
 		_, err := db.Exec(q)
@@ -86,11 +193,7 @@ func CleanupTestApis(db *sql.DB) {
 }
 
 func TestApis(t *testing.T) {
-	db, err := sql.Open("mysql", DSN)
-	err = db.Ping()
-	if err != nil {
-		panic(err)
-	}
+	db := openTestDB()
 
 	PrepareTestApis(db)
 
@@ -468,10 +571,34 @@ func TestApis(t *testing.T) {
 			},
 		},
 		// тут тоже возможна sql-инъекция
-		// если пришло не число на вход - берём дефолтное значене для лимита-оффсета
+		// если пришло не число на вход - отвечаем 400
 		Case{ // 28
-			Path:  "/users",
-			Query: "limit=1'&offset=1\"",
+			Path:   "/users",
+			Query:  "limit=1'&offset=1\"",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "bad offset value",
+			},
+		},
+		Case{ // 29
+			Path:   "/users",
+			Query:  "limit=-1",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "bad limit value",
+			},
+		},
+		// всю таблицу разом можно получить, только если это явно разрешено в настройках
+		Case{ // 30
+			Path:   "/users",
+			Query:  "limit=all",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unbounded queries are not allowed for this table",
+			},
+		},
+		Case{ // 31
+			Path: "/users",
 			Result: CR{
 				"response": CR{
 					"records": []CR{
@@ -500,33 +627,2769 @@ func TestApis(t *testing.T) {
 	runCases(t, ts, db, cases)
 }
 
-func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
-	for idx, item := range cases {
-		var (
-			err      error
-			result   interface{}
-			expected interface{}
-			req      *http.Request
-		)
+func TestMultiDb(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
 
-		caseName := fmt.Sprintf("case %d: [%s] %s %s", idx, item.Method, item.Path, item.Query)
+	handler, err := NewMultiDbExplorer([]NamedDB{
+		{Name: "main", Db: db},
+		{Name: "reports", Db: db},
+	})
+	if err != nil {
+		panic(err)
+	}
 
-		// если у вас случилась это ошибка - значит вы не делаете где-то rows.Close и у вас текут соединения с базой
-		// если такое случилось на первом тесте - значит вы не закрываете коннект где-то при иницаилизации в NewDbExplorer
-		if db.Stats().OpenConnections != 1 {
-			t.Fatalf("[%s] you have %d open connections, must be 1", caseName, db.Stats().OpenConnections)
-		}
+	ts := httptest.NewServer(handler)
 
-		if item.Method == "" || item.Method == http.MethodGet {
-			req, err = http.NewRequest(item.Method, ts.URL+item.Path+"?"+item.Query, nil)
-		} else {
-			data, err := json.Marshal(item.Body)
-			if err != nil {
-				panic(err)
-			}
-			reqBody := bytes.NewReader(data)
-			req, err = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
-			req.Header.Add("Content-Type", "application/json")
+	cases := []Case{
+		Case{ // 0
+			Path: "/", // список баз
+			Result: CR{
+				"response": CR{
+					"databases": []string{"main", "reports"},
+				},
+			},
+		},
+		Case{ // 1
+			Path: "/db/reports/", // список таблиц базы
+			Result: CR{
+				"response": CR{
+					"tables": []string{"items", "users"},
+				},
+			},
+		},
+		Case{ // 2
+			Path: "/db/main/items/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":          1,
+						"title":       "database/sql",
+						"description": "Рассказать про базы данных",
+						"updated":     "rvasily",
+					},
+				},
+			},
+		},
+		Case{ // 3
+			Path:   "/db/unknown/items",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown database",
+			},
+		},
+		Case{ // 4
+			Path:   "/db/main/unknown_table",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown table",
+			},
+		},
+		Case{ // 5 - список баз проверяет ?format так же, как маршруты базы
+			Path:   "/",
+			Query:  "format=csv",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unsupported format csv",
+			},
+		},
+		Case{ // 6
+			Path:   "/db/main/items/1",
+			Query:  "format=bogus",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unsupported format bogus",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestMultiDbSettings(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.TableSettings = map[string]TableConfig{
+		"items": {HiddenColumns: []string{"updated"}},
+	}
+	// у reports свои настройки items, они целиком заменяют общие
+	cfg.Databases = []DatabaseConfig{
+		{Name: "main"},
+		{Name: "reports", TableSettings: map[string]TableConfig{
+			"items": {HiddenColumns: []string{"description"}},
+		}},
+	}
+	handler, err := NewMultiDbExplorerWithConfig([]NamedDB{
+		{Name: "main", Db: db},
+		{Name: "reports", Db: db},
+	}, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	cases := []Case{
+		Case{ // 0
+			Path: "/db/main/items/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":          1,
+						"title":       "database/sql",
+						"description": "Рассказать про базы данных",
+					},
+				},
+			},
+		},
+		Case{ // 1
+			Path: "/db/reports/items/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":      1,
+						"title":   "database/sql",
+						"updated": "rvasily",
+					},
+				},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// спецификация каждой базы описывает её пути относительно /db/$name
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/db/reports/_openapi.json", nil))
+	var spec struct {
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Paths map[string]interface{} `json:"paths"`
+	}
+	if err = json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if len(spec.Servers) != 1 || spec.Servers[0].URL != "/db/reports" {
+		t.Errorf("bad servers %+v", spec.Servers)
+	}
+	if _, ok := spec.Paths["/items/{id}"]; !ok {
+		t.Errorf("paths must be relative to the server: %v", spec.Paths)
+	}
+
+	// $id JSON Schema - адрес, по которому её запросили, вместе с /db/$name
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/db/reports/items/_jsonschema?payload=create", nil))
+	var schema struct {
+		ID string `json:"$id"`
+	}
+	if err = json.Unmarshal(rec.Body.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.ID != "/db/reports/items/_jsonschema?payload=create" {
+		t.Errorf("bad $id %q", schema.ID)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	allow := true
+	cfg := DefaultConfig()
+	cfg.TableSettings = map[string]TableConfig{
+		"items": {Pagination: TablePaginationConfig{DefaultLimit: 1, MaxLimit: 1}},
+		"users": {Pagination: TablePaginationConfig{AllowUnbounded: &allow}},
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	item1 := CR{
+		"id":          1,
+		"title":       "database/sql",
+		"description": "Рассказать про базы данных",
+		"updated":     "rvasily",
+	}
+	cases := []Case{
+		Case{ // 0 - limit по умолчанию из настроек таблицы
+			Path: "/items",
+			Result: CR{
+				"response": CR{"records": []CR{item1}},
+			},
+		},
+		Case{ // 1 - больше max_limit не отдаём
+			Path:  "/items",
+			Query: "limit=100",
+			Result: CR{
+				"response": CR{"records": []CR{item1}},
+			},
+		},
+		Case{ // 2
+			Path:  "/users",
+			Query: "limit=all",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"user_id":  1,
+							"login":    "rvasily",
+							"password": "love",
+							"email":    "rvasily@example.com",
+							"info":     "none",
+							"updated":  nil,
+						},
+					},
+				},
+			},
+		},
+		Case{ // 3
+			Path:   "/users",
+			Query:  "limit=all&offset=1",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "offset can't be used with limit=all",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestColumnSettings(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.Tables.Exclude = []string{"it*"}
+	cfg.TableSettings = map[string]TableConfig{
+		"users": {
+			HiddenColumns:    []string{"updated"},
+			ReadOnlyColumns:  []string{"login"},
+			WriteOnlyColumns: []string{"password"},
+		},
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	cases := []Case{
+		Case{ // 0 - items исключены шаблоном
+			Path: "/",
+			Result: CR{
+				"response": CR{"tables": []string{"users"}},
+			},
+		},
+		Case{ // 1
+			Path:   "/items",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown table",
+			},
+		},
+		Case{ // 2 - скрытого updated и write only password в ответе нет
+			Path: "/users/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"user_id": 1,
+						"login":   "rvasily",
+						"email":   "rvasily@example.com",
+						"info":    "none",
+					},
+				},
+			},
+		},
+		Case{ // 3 - write only поле можно обновить, скрытое молча игнорируется
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Body: CR{
+				"password": "secret",
+				"updated":  "ignored",
+			},
+			Result: CR{
+				"response": CR{"updated": 1},
+			},
+		},
+		Case{ // 4
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"login": "admin",
+			},
+			Result: CR{
+				"error": "field login is read only",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	var password string
+	var updated sql.NullString
+	err = db.QueryRow("SELECT password, updated FROM users WHERE user_id = 1").Scan(&password, &updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password != "secret" || updated.Valid {
+		t.Errorf("unexpected users row: password=%q updated=%v", password, updated)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.TableSettings = map[string]TableConfig{
+		"users": {ReadOnlyColumns: []string{"updated"}, WriteOnlyColumns: []string{"password"}},
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_openapi.json", nil))
+	var spec struct {
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties           map[string]map[string]interface{} `json:"properties"`
+				Required             []string                          `json:"required"`
+				AdditionalProperties *bool                             `json:"additionalProperties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err = json.Unmarshal(rec.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if got := strings.Join(paths, " "); got != "/ /items /items/{id} /users /users/{id}" {
+		t.Errorf("bad paths %s", got)
+	}
+	for path, methods := range map[string]string{"/items": "get put", "/items/{id}": "delete get parameters post"} {
+		names := make([]string, 0)
+		for name := range spec.Paths[path] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, " "); got != methods {
+			t.Errorf("%s: bad methods %s", path, got)
+		}
+	}
+
+	propertiesOf := func(name string) string {
+		schema, ok := spec.Components.Schemas[name]
+		if !ok {
+			t.Fatalf("no schema %s", name)
+		}
+		names := make([]string, 0, len(schema.Properties))
+		for prop := range schema.Properties {
+			names = append(names, prop)
+		}
+		sort.Strings(names)
+		return strings.Join(names, " ")
+	}
+	for name, want := range map[string]string{
+		// write only password не отдаётся, read only updated отдаётся
+		"users_record": "email info login updated user_id",
+		// первичный ключ и read only поля в теле не передаются, write only - передаются
+		"users_create": "email info login password",
+		"users_update": "email info login password",
+		"items_record": "description id title updated",
+		"items_create": "description title updated",
+		"items_update": "description title updated",
+	} {
+		if got := propertiesOf(name); got != want {
+			t.Errorf("%s: got properties %q, want %q", name, got, want)
+		}
+	}
+
+	items := spec.Components.Schemas["items_record"]
+	if !reflect.DeepEqual(items.Required, []string{"id", "title", "description", "updated"}) {
+		t.Errorf("bad required fields of items_record %v", items.Required)
+	}
+	if items.Properties["updated"]["nullable"] != true || items.Properties["title"]["nullable"] != nil {
+		t.Errorf("only updated must be nullable: %v", items.Properties)
+	}
+	list := spec.Paths["/items"]["get"].(map[string]interface{})
+	if _, ok := list["responses"].(map[string]interface{})["400"]; !ok {
+		t.Error("list must document 400 response")
+	}
+	limit := list["parameters"].([]interface{})[0].(map[string]interface{})
+	want := map[string]interface{}{"oneOf": []interface{}{
+		map[string]interface{}{"type": "integer", "minimum": 0.0},
+		map[string]interface{}{"type": "string", "enum": []interface{}{"all"}},
+	}}
+	if limit["name"] != "limit" || !reflect.DeepEqual(limit["schema"], want) {
+		t.Errorf("bad limit parameter %v", limit)
+	}
+	for _, name := range []string{"items_create", "items_update", "users_create", "users_update"} {
+		if ap := spec.Components.Schemas[name].AdditionalProperties; ap == nil || *ap {
+			t.Errorf("%s must not allow additional properties", name)
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.TableSettings = map[string]TableConfig{
+		"users": {ReadOnlyColumns: []string{"updated"}, WriteOnlyColumns: []string{"password"}},
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	schemaOf := func(path string) map[string]interface{} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %s", path, rec.Code, rec.Body.String())
+		}
+		schema := make(map[string]interface{})
+		if err := json.Unmarshal(rec.Body.Bytes(), &schema); err != nil {
+			t.Fatal(err)
+		}
+		return schema
+	}
+
+	items := schemaOf("/items/_jsonschema?payload=create")
+	if items["$schema"] != jsonSchemaDialect || items["additionalProperties"] != false {
+		t.Errorf("bad items create schema %v", items)
+	}
+	// пропущенные при создании поля получают значения по умолчанию
+	if _, ok := items["required"]; ok {
+		t.Errorf("create schema must have no required fields: %v", items["required"])
+	}
+	props := items["properties"].(map[string]interface{})
+	if _, ok := props["id"]; ok {
+		t.Error("primary key must not be in create schema")
+	}
+	wantTypes := map[string]interface{}{
+		"title":       "string",
+		"description": "string",
+		"updated":     []interface{}{"string", "null"},
+	}
+	for field, want := range wantTypes {
+		if got := props[field].(map[string]interface{})["type"]; !reflect.DeepEqual(got, want) {
+			t.Errorf("items.%s: got type %v, want %v", field, got, want)
+		}
+	}
+
+	users := schemaOf("/users/_jsonschema")
+	defs := users["$defs"].(map[string]interface{})
+	create := defs["create"].(map[string]interface{})["properties"].(map[string]interface{})
+	update := defs["update"].(map[string]interface{})["properties"].(map[string]interface{})
+	// read only поле задать нельзя ни при создании, ни при обновлении, write only - можно
+	for name, props := range map[string]map[string]interface{}{"create": create, "update": update} {
+		if props["updated"] != false {
+			t.Errorf("%s: read only updated must be false, got %v", name, props["updated"])
+		}
+		if _, ok := props["password"].(map[string]interface{}); !ok {
+			t.Errorf("%s: write only password must be writable, got %v", name, props["password"])
+		}
+		if defs[name].(map[string]interface{})["additionalProperties"] != false {
+			t.Errorf("%s: additional properties must be forbidden", name)
+		}
+	}
+	// первичный ключ при создании игнорируется, а при обновлении запрещён
+	if _, ok := create["user_id"]; ok {
+		t.Error("primary key must not be in create schema")
+	}
+	if update["user_id"] != false {
+		t.Errorf("primary key must be false in update schema, got %v", update["user_id"])
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+	for _, q := range []string{
+		`DROP TABLE IF EXISTS api_keys;`,
+		`CREATE TABLE api_keys (label varchar(255) NOT NULL, key_hash varchar(255) NOT NULL);`,
+		fmt.Sprintf(`INSERT INTO api_keys (label, key_hash) VALUES ('ci', '%s');`, hashAPIKey("from-table")),
+	} {
+		if _, err := db.Exec(q); err != nil {
+			panic(err)
+		}
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS api_keys;`)
+
+	cfg := DefaultConfig()
+	cfg.Auth = AuthConfig{
+		APIKeys:      []APIKeyConfig{{Label: "admin", Hash: hashAPIKey("s3cret")}},
+		APIKeysTable: "api_keys",
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	cases := []Case{
+		Case{ // 0
+			Path:   "/",
+			Status: http.StatusUnauthorized,
+			Result: CR{
+				"error": "authorization required",
+			},
+		},
+		Case{ // 1
+			Path:    "/",
+			Status:  http.StatusUnauthorized,
+			Headers: map[string]string{"Authorization": "Bearer wrong"},
+			Result: CR{
+				"error": "invalid api key",
+			},
+		},
+		Case{ // 2 - таблица ключей наружу не отдаётся
+			Path:    "/",
+			Headers: map[string]string{"Authorization": "Bearer s3cret"},
+			Result: CR{
+				"response": CR{"tables": []string{"items", "users"}},
+			},
+		},
+		Case{ // 3
+			Path:    "/items/2",
+			Headers: map[string]string{"X-API-Key": "from-table"},
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":          2,
+						"title":       "memcache",
+						"description": "Рассказать про мемкеш с примером использования",
+						"updated":     nil,
+					},
+				},
+			},
+		},
+		Case{ // 4
+			Path:   "/items/2",
+			Method: http.MethodDelete,
+			Status: http.StatusUnauthorized,
+			Result: CR{
+				"error": "authorization required",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestJWT(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.Auth.JWT = JWTConfig{Secret: "jwt-secret"}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	reader := "Bearer " + signTestJWT(t, []byte("jwt-secret"), "", map[string]interface{}{
+		"sub":   "analyst",
+		"scope": "*:read",
+	})
+	writer := "Bearer " + signTestJWT(t, []byte("jwt-secret"), "", map[string]interface{}{
+		"sub":   "support",
+		"scope": []string{"items:read", "items:write"},
+	})
+	cases := []Case{
+		Case{ // 0
+			Path:    "/items/1",
+			Status:  http.StatusUnauthorized,
+			Headers: map[string]string{"Authorization": "Bearer " + signTestJWT(t, []byte("wrong"), "", CR{"scope": "*"})},
+			Result: CR{
+				"error": "invalid token: bad token signature",
+			},
+		},
+		Case{ // 1
+			Path:    "/users/1",
+			Headers: map[string]string{"Authorization": reader},
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"user_id":  1,
+						"login":    "rvasily",
+						"password": "love",
+						"email":    "rvasily@example.com",
+						"info":     "none",
+						"updated":  nil,
+					},
+				},
+			},
+		},
+		Case{ // 2 - токен валиден, но прав на запись нет
+			Path:    "/items/1",
+			Method:  http.MethodPost,
+			Status:  http.StatusForbidden,
+			Headers: map[string]string{"Authorization": reader},
+			Body:    CR{"title": "changed"},
+			Result: CR{
+				"error": "insufficient scope, items:write required",
+			},
+		},
+		Case{ // 3
+			Path:    "/items/1",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"Authorization": writer},
+			Body:    CR{"title": "changed"},
+			Result: CR{
+				"response": CR{"updated": 1},
+			},
+		},
+		Case{ // 4
+			Path:    "/users",
+			Status:  http.StatusForbidden,
+			Headers: map[string]string{"Authorization": writer},
+			Result: CR{
+				"error": "insufficient scope, users:read required",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestPolicy(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.Auth = AuthConfig{
+		APIKeys: []APIKeyConfig{
+			{Label: "ci", Hash: hashAPIKey("admin-key")},
+			{Label: "helpdesk", Hash: hashAPIKey("support-key")},
+		},
+		PolicyFile: writeTestPolicy(t, testPolicy),
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	support := map[string]string{"X-API-Key": "support-key"}
+	cases := []Case{
+		Case{ // 0 - в списке только доступные таблицы
+			Path:    "/",
+			Headers: support,
+			Result: CR{
+				"response": CR{"tables": []string{"items"}},
+			},
+		},
+		Case{ // 1 - колонки, не упомянутые в политике, не отдаются
+			Path:    "/items/1",
+			Headers: support,
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":          1,
+						"title":       "database/sql",
+						"description": "Рассказать про базы данных",
+					},
+				},
+			},
+		},
+		Case{ // 2
+			Path:    "/items/1",
+			Method:  http.MethodPost,
+			Headers: support,
+			Status:  http.StatusForbidden,
+			Body:    CR{"updated": "support"},
+			Result: CR{
+				"error": "access denied: column updated of items is not writable for roles [support]",
+			},
+		},
+		Case{ // 3
+			Path:    "/items/1",
+			Method:  http.MethodPost,
+			Headers: support,
+			Body:    CR{"title": "sql"},
+			Result: CR{
+				"response": CR{"updated": 1},
+			},
+		},
+		Case{ // 4
+			Path:    "/items/1",
+			Method:  http.MethodDelete,
+			Headers: support,
+			Status:  http.StatusForbidden,
+			Result: CR{
+				"error": "access denied: DELETE items is not allowed for roles [support]",
+			},
+		},
+		Case{ // 5
+			Path:    "/users/1",
+			Method:  http.MethodDelete,
+			Headers: map[string]string{"X-API-Key": "admin-key"},
+			Result: CR{
+				"response": CR{"deleted": 1},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// тело импорта политика не читает, колонки каждой строки проверяет обработчик
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/items/_import", strings.NewReader("title,updated\nimported,support\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Set("X-API-Key", "support-key")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "access denied: column updated of items is not writable") {
+		t.Errorf("import with forbidden column: got %d %s", resp.StatusCode, body)
+	}
+
+	// спецификация и JSON Schema не называют таблиц и колонок, закрытых политикой
+	getSupport := func(path string, v interface{}) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("X-API-Key", "support-key")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	type objectSchema struct {
+		Properties map[string]interface{} `json:"properties"`
+	}
+	propertyNames := func(schema objectSchema) string {
+		names := make([]string, 0, len(schema.Properties))
+		for name := range schema.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, " ")
+	}
+	var spec struct {
+		Paths      map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]objectSchema `json:"schemas"`
+		} `json:"components"`
+	}
+	getSupport("/_openapi.json", &spec)
+	paths := make([]string, 0, len(spec.Paths))
+	for path := range spec.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if got := strings.Join(paths, " "); got != "/ /items /items/{id}" {
+		t.Errorf("support: bad openapi paths %s", got)
+	}
+	if _, ok := spec.Components.Schemas["users_record"]; ok {
+		t.Errorf("support: openapi must not describe users")
+	}
+	if got := propertyNames(spec.Components.Schemas["items_record"]); got != "description id title" {
+		t.Errorf("support: bad items_record properties %s", got)
+	}
+	var schema struct {
+		Defs map[string]objectSchema `json:"$defs"`
+	}
+	getSupport("/items/_jsonschema", &schema)
+	if got := propertyNames(schema.Defs["create"]); got != "description title" {
+		t.Errorf("support: bad json schema properties %s", got)
+	}
+}
+
+func TestRowPolicy(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.Auth.JWT = JWTConfig{Secret: "jwt-secret"}
+	// владельцем записи в items считаем updated, так не нужна отдельная тестовая таблица
+	cfg.TableSettings = map[string]TableConfig{
+		"items": {RowPolicy: []string{"updated = :claims.tenant"}},
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	tenant := map[string]string{"Authorization": "Bearer " + signTestJWT(t, []byte("jwt-secret"), "", CR{
+		"sub":    "rvasily",
+		"scope":  "*",
+		"tenant": "rvasily",
+	})}
+	cases := []Case{
+		Case{ // 0 - чужие строки не видны
+			Path:    "/items",
+			Headers: tenant,
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":          1,
+							"title":       "database/sql",
+							"description": "Рассказать про базы данных",
+							"updated":     "rvasily",
+						},
+					},
+				},
+			},
+		},
+		Case{ // 1
+			Path:    "/items/2",
+			Headers: tenant,
+			Status:  http.StatusNotFound,
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{ // 2 - колонка политики заполняется сама
+			Path:    "/items",
+			Method:  http.MethodPut,
+			Headers: tenant,
+			Body: CR{
+				"title":       "tenant",
+				"description": "own record",
+			},
+			Result: CR{
+				"response": CR{"id": 3},
+			},
+		},
+		Case{ // 3
+			Path:    "/items/3",
+			Headers: tenant,
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":          3,
+						"title":       "tenant",
+						"description": "own record",
+						"updated":     "rvasily",
+					},
+				},
+			},
+		},
+		Case{ // 4
+			Path:    "/items/3",
+			Method:  http.MethodPost,
+			Headers: tenant,
+			Status:  http.StatusBadRequest,
+			Body:    CR{"updated": "someone else"},
+			Result: CR{
+				"error": "field updated is set by row policy",
+			},
+		},
+		Case{ // 5
+			Path:    "/items/2",
+			Method:  http.MethodPost,
+			Headers: tenant,
+			Status:  http.StatusNotFound,
+			Body:    CR{"title": "stolen"},
+			Result: CR{
+				"error": "sql: no rows in result set",
+			},
+		},
+		Case{ // 6
+			Path:    "/items/2",
+			Method:  http.MethodDelete,
+			Headers: tenant,
+			Result: CR{
+				"response": CR{"deleted": 0},
+			},
+		},
+		Case{ // 7
+			Path:    "/items",
+			Headers: map[string]string{"Authorization": "Bearer " + signTestJWT(t, []byte("jwt-secret"), "", CR{"scope": "*"})},
+			Status:  http.StatusForbidden,
+			Result: CR{
+				"error": "access denied: table items requires claim tenant",
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestRowPolicyNumericClaim(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+	dialect, _ := detectDialect(db)
+	if _, err := db.Exec(`ALTER TABLE items ADD COLUMN tenant_id int DEFAULT NULL`); err != nil {
+		panic(err)
+	}
+	db.Exec(`DROP TABLE IF EXISTS history_log`)
+	if _, err := db.Exec(strings.Replace(auditTableSchemas[dialect.Name()], "audit_log", "history_log", 1)); err != nil {
+		panic(err)
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS history_log`)
+
+	cfg := DefaultConfig()
+	cfg.Auth.JWT = JWTConfig{Secret: "jwt-secret"}
+	cfg.TableSettings = map[string]TableConfig{
+		"items": {RowPolicy: []string{"tenant_id = :claims.tenant"}},
+	}
+	cfg.History = HistoryConfig{Table: "history_log", Tables: []string{"items"}}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	// claim приходит int64, а число из тела JSON - float64, 1000000 в нём печатается как 1e+06
+	tenant := map[string]string{"Authorization": "Bearer " + signTestJWT(t, []byte("jwt-secret"), "", CR{
+		"sub":    "rvasily",
+		"scope":  "*",
+		"tenant": 1000000,
+	})}
+	cases := []Case{
+		Case{ // 0
+			Path:    "/items",
+			Method:  http.MethodPut,
+			Headers: tenant,
+			Body:    CR{"title": "big tenant", "description": "own record", "tenant_id": 1000000},
+			Result:  CR{"response": CR{"id": 3}},
+		},
+		Case{ // 1
+			Path:    "/items/3",
+			Method:  http.MethodPost,
+			Headers: tenant,
+			Body:    CR{"title": "renamed", "tenant_id": 1000000},
+			Result:  CR{"response": CR{"updated": 1}},
+		},
+		Case{ // 2
+			Path:    "/items/3",
+			Method:  http.MethodPost,
+			Headers: tenant,
+			Body:    CR{"tenant_id": 2000000},
+			Status:  http.StatusBadRequest,
+			Result:  CR{"error": "field tenant_id is set by row policy"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// история читается из JSON, tenant_id в ней тоже float64 - своему тенанту она должна быть видна
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/items/3/_history", nil)
+	req.Header.Set("Authorization", tenant["Authorization"])
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Response struct {
+			History []*rowChange `json:"history"`
+		} `json:"response"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Response.History) != 2 {
+		t.Errorf("expected create and update in history of own record, got %d entries", len(result.Response.History))
+	}
+}
+
+func TestReadOnlyMode(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.DisabledMethods = []string{"DELETE"}
+	cfg.TableSettings = map[string]TableConfig{
+		"users": {ReadOnly: true},
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	cases := []Case{
+		Case{ // 0
+			Path:   "/items/1",
+			Method: http.MethodDelete,
+			Status: http.StatusServiceUnavailable,
+			Result: CR{
+				"error": "method DELETE is disabled",
+			},
+		},
+		Case{ // 1
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Status: http.StatusServiceUnavailable,
+			Body:   CR{"info": "changed"},
+			Result: CR{
+				"error": "table users is in read-only mode",
+			},
+		},
+		Case{ // 2 - переключаем на ходу
+			Path:   "/_admin/read_only",
+			Method: http.MethodPost,
+			Body: CR{
+				"read_only":        true,
+				"disabled_methods": []string{},
+			},
+			Result: CR{
+				"response": CR{
+					"read_only":        true,
+					"tables":           []string{"users"},
+					"disabled_methods": []string{},
+				},
+			},
+		},
+		Case{ // 3
+			Path:   "/items",
+			Method: http.MethodPut,
+			Status: http.StatusServiceUnavailable,
+			Body:   CR{"title": "frozen"},
+			Result: CR{
+				"error": "explorer is in read-only mode",
+			},
+		},
+		Case{ // 4 - чтение работает
+			Path: "/items/2",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":          2,
+						"title":       "memcache",
+						"description": "Рассказать про мемкеш с примером использования",
+						"updated":     nil,
+					},
+				},
+			},
+		},
+		Case{ // 5
+			Path:   "/_admin/read_only",
+			Method: http.MethodPost,
+			Body: CR{
+				"read_only": false,
+				"tables":    CR{"users": false},
+			},
+			Result: CR{
+				"response": CR{
+					"read_only":        false,
+					"tables":           []string{},
+					"disabled_methods": []string{},
+				},
+			},
+		},
+		Case{ // 6
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Body:   CR{"info": "changed"},
+			Result: CR{
+				"response": CR{"updated": 1},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	// GET читает из ReadDb, всё остальное - из основной базы
+	replica := &sql.DB{}
+	handler.ReadDb = replica
+	if handler.reader(context.WithValue(context.Background(), readRequestKey{}, true)) != replica {
+		t.Error("GET request must use ReadDb")
+	}
+	if handler.reader(context.Background()) != db {
+		t.Error("write request must use Db")
+	}
+}
+
+// auditTableSchemas - таблица аудита из audit.go для каждого диалекта
+var auditTableSchemas = map[string]string{
+	"mysql":    `CREATE TABLE audit_log (id int NOT NULL AUTO_INCREMENT PRIMARY KEY, created_at varchar(32) NOT NULL, principal varchar(255) NOT NULL, table_name varchar(255) NOT NULL, record_id bigint NOT NULL, operation varchar(16) NOT NULL, before_data text, after_data text)`,
+	"postgres": `CREATE TABLE audit_log (id serial PRIMARY KEY, created_at varchar(32) NOT NULL, principal varchar(255) NOT NULL, table_name varchar(255) NOT NULL, record_id bigint NOT NULL, operation varchar(16) NOT NULL, before_data text, after_data text)`,
+	"sqlite":   `CREATE TABLE audit_log (id INTEGER PRIMARY KEY AUTOINCREMENT, created_at varchar(32) NOT NULL, principal varchar(255) NOT NULL, table_name varchar(255) NOT NULL, record_id bigint NOT NULL, operation varchar(16) NOT NULL, before_data text, after_data text)`,
+}
+
+func TestAudit(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+	dialect, _ := detectDialect(db)
+	db.Exec(`DROP TABLE IF EXISTS audit_log`)
+	if _, err := db.Exec(auditTableSchemas[dialect.Name()]); err != nil {
+		panic(err)
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS audit_log`)
+
+	cfg := DefaultConfig()
+	cfg.Audit = AuditConfig{Table: "audit_log", File: filepath.Join(t.TempDir(), "audit.jsonl")}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	cases := []Case{
+		Case{ // 0 - таблица аудита наружу не отдаётся
+			Path: "/",
+			Result: CR{
+				"response": CR{"tables": []string{"items", "users"}},
+			},
+		},
+		Case{ // 1
+			Path:   "/items",
+			Method: http.MethodPut,
+			Body:   CR{"title": "audited", "description": "x"},
+			Result: CR{
+				"response": CR{"id": 3},
+			},
+		},
+		Case{ // 2
+			Path:   "/items/3",
+			Method: http.MethodPost,
+			Body:   CR{"title": "changed"},
+			Result: CR{
+				"response": CR{"updated": 1},
+			},
+		},
+		Case{ // 3
+			Path:   "/items/3",
+			Method: http.MethodDelete,
+			Result: CR{
+				"response": CR{"deleted": 1},
+			},
+		},
+		Case{ // 4 - удаление несуществующей записи в аудит не попадает
+			Path:   "/items/3",
+			Method: http.MethodDelete,
+			Result: CR{
+				"response": CR{"deleted": 0},
+			},
+		},
+		Case{ // 5
+			Path:   "/_audit",
+			Query:  "since=yesterday",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "bad since value, RFC 3339 expected",
+			},
+		},
+		Case{ // 6 - limit=0 - пустая страница, а не бесконечный перебор таблицы аудита
+			Path:  "/_audit",
+			Query: "limit=0",
+			Result: CR{
+				"response": CR{"entries": []CR{}},
+			},
+		},
+		Case{ // 7
+			Path:  "/_audit",
+			Query: "limit=0&offset=1",
+			Result: CR{
+				"response": CR{"entries": []CR{}},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	checkEntries := func(source string, entries []*rowChange) {
+		if len(entries) != 3 {
+			t.Fatalf("%s: expected 3 entries, got %d", source, len(entries))
+		}
+		ops := []string{entries[0].Operation, entries[1].Operation, entries[2].Operation}
+		if strings.Join(ops, ",") != "delete,update,create" {
+			t.Errorf("%s: bad operations order %v", source, ops)
+		}
+		upd := entries[1]
+		if upd.Table != "items" || upd.ID != 3 || upd.Principal != "anonymous" ||
+			upd.Before["title"] != "audited" || upd.After["title"] != "changed" {
+			t.Errorf("%s: bad update entry %+v", source, upd)
+		}
+		if entries[0].After != nil || entries[2].Before != nil {
+			t.Errorf("%s: create must have no before image, delete - no after image", source)
+		}
+	}
+
+	resp, err := client.Get(ts.URL + "/_audit?table=items&limit=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Response struct {
+			Entries []*rowChange `json:"entries"`
+		} `json:"response"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	checkEntries("table", result.Response.Entries)
+
+	fromFile := make([]*rowChange, 0)
+	err = handler.readAuditFile(auditFilter{}, func(ch *rowChange) bool {
+		fromFile = append(fromFile, ch)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEntries("file", fromFile)
+
+	// аудит пишется в той же транзакции: не записался аудит - не записалась и сама запись
+	db.Exec(`DROP TABLE audit_log`)
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/items", strings.NewReader(`{"title": "lost", "description": "x"}`))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected 500 without audit table, got %d", resp.StatusCode)
+	}
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM items WHERE title = 'lost'`).Scan(&count)
+	if count != 0 {
+		t.Errorf("item must not be created without audit record")
+	}
+}
+
+func TestAuditVisibility(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.Audit = AuditConfig{File: filepath.Join(t.TempDir(), "audit.jsonl")}
+	cfg.Auth = AuthConfig{
+		APIKeys: []APIKeyConfig{
+			{Label: "ci", Hash: hashAPIKey("admin-key")},
+			{Label: "reports", Hash: hashAPIKey("analyst-key")},
+			{Label: "helpdesk", Hash: hashAPIKey("support-key")},
+		},
+		PolicyFile: writeTestPolicy(t, `
+bindings:
+  ci: [admin]
+  reports: [analyst]
+  helpdesk: [support]
+roles:
+  admin:
+    - tables: ["*", _audit]
+      methods: ["*"]
+  analyst:
+    - tables: ["*", _audit]
+      methods: [GET]
+    - tables: [users]
+      methods: ["*"]
+      deny: true
+  support:
+    - tables: [items]
+      methods: [GET]
+      columns: [title]
+    - tables: [_audit]
+      methods: [GET]
+`),
+	}
+	// обе базы пишут в один файл аудита
+	handler, err := NewMultiDbExplorerWithConfig([]NamedDB{
+		{Name: "main", Db: db},
+		{Name: "reports", Db: db},
+	}, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	do := func(method, path, key, body string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("X-API-Key", key)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	for _, w := range []struct{ path, body string }{
+		{"/db/main/items", `{"title": "main item", "description": "x"}`},
+		{"/db/main/users", `{"login": "audited", "password": "secret", "email": "a@example.com", "info": "x"}`},
+		{"/db/reports/items", `{"title": "reports item", "description": "x"}`},
+	} {
+		resp := do(http.MethodPut, w.path, "admin-key", w.body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT %s: got %d", w.path, resp.StatusCode)
+		}
+	}
+
+	entries := func(path, key string) []*rowChange {
+		resp := do(http.MethodGet, path, key, "")
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: got %d", path, resp.StatusCode)
+		}
+		var result struct {
+			Response struct {
+				Entries []*rowChange `json:"entries"`
+			} `json:"response"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return result.Response.Entries
+	}
+	tablesOf := func(entries []*rowChange) string {
+		tables := make([]string, 0, len(entries))
+		for _, ch := range entries {
+			tables = append(tables, ch.Database+"."+ch.Table)
+		}
+		return strings.Join(tables, ",")
+	}
+
+	if got := tablesOf(entries("/db/main/_audit", "admin-key")); got != "main.users,main.items" {
+		t.Errorf("admin audit of main: %s", got)
+	}
+	if got := tablesOf(entries("/db/reports/_audit", "admin-key")); got != "reports.items" {
+		t.Errorf("admin audit of reports: %s", got)
+	}
+	// users закрыта для analyst, и offset отсчитывается уже по видимым записям
+	if got := tablesOf(entries("/db/main/_audit", "analyst-key")); got != "main.items" {
+		t.Errorf("analyst audit of main: %s", got)
+	}
+	if got := entries("/db/main/_audit?offset=1", "analyst-key"); len(got) != 0 {
+		t.Errorf("analyst audit of main with offset: %s", tablesOf(got))
+	}
+	// support видит только title и первичный ключ
+	support := entries("/db/main/_audit", "support-key")
+	if len(support) != 1 || support[0].Table != "items" {
+		t.Fatalf("support audit of main: %s", tablesOf(support))
+	}
+	after := support[0].After
+	if len(after) != 2 || after["title"] != "main item" || after["id"] == nil {
+		t.Errorf("support must see only id and title: %v", after)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+	dialect, _ := detectDialect(db)
+	db.Exec(`DROP TABLE IF EXISTS history_log`)
+	if _, err := db.Exec(strings.Replace(auditTableSchemas[dialect.Name()], "audit_log", "history_log", 1)); err != nil {
+		panic(err)
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS history_log`)
+
+	cfg := DefaultConfig()
+	cfg.History = HistoryConfig{Table: "history_log", Tables: []string{"items"}}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	runCases(t, ts, db, []Case{
+		Case{Path: "/items", Method: http.MethodPut, Body: CR{"title": "v1", "description": "d"}, Result: CR{"response": CR{"id": 3}}},
+		Case{Path: "/items/3", Method: http.MethodPost, Body: CR{"title": "v2"}, Result: CR{"response": CR{"updated": 1}}},
+		Case{Path: "/items/1", Method: http.MethodPost, Body: CR{"title": "sql v2"}, Result: CR{"response": CR{"updated": 1}}},
+		Case{Path: "/items/3", Method: http.MethodDelete, Result: CR{"response": CR{"deleted": 1}}},
+	})
+	// разносим изменения по времени, чтобы было что восстанавливать
+	for id, month := range map[int]string{1: "01", 2: "02", 3: "03", 4: "04"} {
+		_, err = db.Exec(fmt.Sprintf(`UPDATE history_log SET created_at = '2024-%s-01T00:00:00.000000Z' WHERE id = %d`, month, id))
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	item := func(id int, title, description string, updated interface{}) CR {
+		return CR{"id": id, "title": title, "description": description, "updated": updated}
+	}
+	cases := []Case{
+		Case{ // 0 - запись ещё не создана
+			Path:   "/items/3",
+			Query:  "as_of=2023-12-01T00:00:00Z",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "record not found"},
+		},
+		Case{ // 1
+			Path:   "/items/3",
+			Query:  "as_of=2024-01-15T00:00:00Z",
+			Result: CR{"response": CR{"record": item(3, "v1", "d", nil)}},
+		},
+		Case{ // 2 - момент изменения включается
+			Path:   "/items/3",
+			Query:  "as_of=2024-02-01T00:00:00Z",
+			Result: CR{"response": CR{"record": item(3, "v2", "d", nil)}},
+		},
+		Case{ // 3 - уже удалена
+			Path:   "/items/3",
+			Query:  "as_of=2024-05-01T00:00:00Z",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "record not found"},
+		},
+		Case{ // 4 - запись была до начала истории
+			Path:   "/items/1",
+			Query:  "as_of=2024-02-15T00:00:00Z",
+			Result: CR{"response": CR{"record": item(1, "database/sql", "Рассказать про базы данных", "rvasily")}},
+		},
+		Case{ // 5
+			Path:   "/items/1",
+			Query:  "as_of=2024-03-15T00:00:00Z",
+			Result: CR{"response": CR{"record": item(1, "sql v2", "Рассказать про базы данных", "rvasily")}},
+		},
+		Case{ // 6 - изменений не было
+			Path:   "/items/2",
+			Query:  "as_of=2020-01-01T00:00:00Z",
+			Result: CR{"response": CR{"record": item(2, "memcache", "Рассказать про мемкеш с примером использования", nil)}},
+		},
+		Case{ // 7
+			Path: "/items/3/_history",
+			Result: CR{
+				"response": CR{
+					"history": []CR{
+						CR{"time": "2024-01-01T00:00:00Z", "principal": "anonymous", "operation": "create", "before": nil, "after": item(3, "v1", "d", nil)},
+						CR{"time": "2024-02-01T00:00:00Z", "principal": "anonymous", "operation": "update", "before": item(3, "v1", "d", nil), "after": item(3, "v2", "d", nil)},
+						CR{"time": "2024-04-01T00:00:00Z", "principal": "anonymous", "operation": "delete", "before": item(3, "v2", "d", nil), "after": nil},
+					},
+				},
+			},
+		},
+		Case{ // 8
+			Path:   "/users/1",
+			Query:  "as_of=2024-01-01T00:00:00Z",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "history is disabled for this table"},
+		},
+		Case{ // 9
+			Path:   "/items/1",
+			Query:  "as_of=yesterday",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "bad as_of value, RFC 3339 expected"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestActionRouteMethods(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	// служебный маршрут другим методом не должен обновлять или удалять запись
+	cases := []struct {
+		method, path, allow string
+	}{
+		{http.MethodPost, "/items/1/_history", http.MethodGet},
+		{http.MethodDelete, "/items/1/_history", http.MethodGet},
+		{http.MethodGet, "/items/1/_restore", http.MethodPost},
+		{http.MethodDelete, "/items/1/_restore", http.MethodPost},
+		{http.MethodGet, "/items/_import", http.MethodPost},
+		{http.MethodPut, "/items/_import", http.MethodPost},
+	}
+	for idx, c := range cases {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(`{"title": "overwritten"}`))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != c.allow {
+			t.Errorf("case %d: got %d Allow %q %s, want 405 Allow %q", idx, resp.StatusCode, resp.Header.Get("Allow"), body, c.allow)
+		}
+	}
+
+	var title string
+	if err = db.QueryRow(`SELECT title FROM items WHERE id = 1`).Scan(&title); err != nil || title != "database/sql" {
+		t.Errorf("record 1 must stay untouched, got %q %v", title, err)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+	for _, query := range []string{
+		`ALTER TABLE items ADD COLUMN is_deleted int NOT NULL DEFAULT 0`,
+		`ALTER TABLE users ADD COLUMN deleted_at varchar(32) DEFAULT NULL`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			panic(err)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.TableSettings = map[string]TableConfig{
+		"items": {SoftDelete: "is_deleted"},
+		"users": {SoftDelete: "deleted_at"},
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	item := func(id int, title, description string, updated interface{}, deleted int) CR {
+		return CR{"id": id, "title": title, "description": description, "updated": updated, "is_deleted": deleted}
+	}
+	cases := []Case{
+		Case{ // 0
+			Path:   "/items/1",
+			Method: http.MethodDelete,
+			Result: CR{"response": CR{"deleted": 1}},
+		},
+		Case{ // 1 - повторно удалять нечего
+			Path:   "/items/1",
+			Method: http.MethodDelete,
+			Result: CR{"response": CR{"deleted": 0}},
+		},
+		Case{ // 2
+			Path: "/items",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						item(2, "memcache", "Рассказать про мемкеш с примером использования", nil, 0),
+					},
+				},
+			},
+		},
+		Case{ // 3
+			Path:   "/items/1",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "record not found"},
+		},
+		Case{ // 4
+			Path:  "/items",
+			Query: "include_deleted=true",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						item(1, "database/sql", "Рассказать про базы данных", "rvasily", 1),
+						item(2, "memcache", "Рассказать про мемкеш с примером использования", nil, 0),
+					},
+				},
+			},
+		},
+		Case{ // 5
+			Path:   "/items/1",
+			Query:  "include_deleted=true",
+			Result: CR{"response": CR{"record": item(1, "database/sql", "Рассказать про базы данных", "rvasily", 1)}},
+		},
+		Case{ // 6 - удалённую запись не изменить
+			Path:   "/items/1",
+			Method: http.MethodPost,
+			Body:   CR{"title": "changed"},
+			Status: http.StatusNotFound,
+			Result: CR{"error": "sql: no rows in result set"},
+		},
+		Case{ // 7 - отметку ставит только DELETE
+			Path:   "/items/2",
+			Method: http.MethodPost,
+			Body:   CR{"is_deleted": 1},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "field is_deleted is read only"},
+		},
+		Case{ // 8
+			Path:   "/items",
+			Method: http.MethodPut,
+			Body:   CR{"title": "new", "is_deleted": 1},
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "field is_deleted is read only"},
+		},
+		Case{ // 9
+			Path:   "/items/1/_restore",
+			Method: http.MethodPost,
+			Result: CR{"response": CR{"restored": 1}},
+		},
+		Case{ // 10
+			Path:   "/items/1/_restore",
+			Method: http.MethodPost,
+			Result: CR{"response": CR{"restored": 0}},
+		},
+		Case{ // 11
+			Path:   "/items/1",
+			Result: CR{"response": CR{"record": item(1, "database/sql", "Рассказать про базы данных", "rvasily", 0)}},
+		},
+		Case{ // 12
+			Path:   "/items",
+			Query:  "include_deleted=maybe",
+			Status: http.StatusBadRequest,
+			Result: CR{"error": "bad include_deleted value"},
+		},
+		Case{ // 13 - отметка временем
+			Path:   "/users/1",
+			Method: http.MethodDelete,
+			Result: CR{"response": CR{"deleted": 1}},
+		},
+		Case{ // 14
+			Path:   "/users/1",
+			Status: http.StatusNotFound,
+			Result: CR{"error": "record not found"},
+		},
+		Case{ // 15
+			Path:   "/users/1/_restore",
+			Method: http.MethodPost,
+			Result: CR{"response": CR{"restored": 1}},
+		},
+		Case{ // 16
+			Path: "/users/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"user_id":    1,
+						"login":      "rvasily",
+						"password":   "love",
+						"email":      "rvasily@example.com",
+						"info":       "none",
+						"updated":    nil,
+						"deleted_at": nil,
+					},
+				},
+			},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+
+	var deletedAt sql.NullString
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/users/1", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	db.QueryRow(`SELECT deleted_at FROM users WHERE user_id = 1`).Scan(&deletedAt)
+	if !deletedAt.Valid || deletedAt.String == "" {
+		t.Errorf("deleted_at is not set after soft delete: %v", deletedAt)
+	}
+}
+
+func TestETag(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.TableSettings = map[string]TableConfig{
+		"users": {RequireIfMatch: &[]bool{true}[0]},
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+
+	original := CR{"id": 1, "title": "database/sql", "description": "Рассказать про базы данных", "updated": "rvasily"}
+	changed := CR{"id": 1, "title": "changed", "description": "Рассказать про базы данных", "updated": "rvasily"}
+	etag, changedETag := rowETag(original), rowETag(changed)
+
+	resp, err := client.Get(ts.URL + "/items/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("ETag"); got != etag {
+		t.Fatalf("expected ETag %s, got %s", etag, got)
+	}
+
+	// у каждого представления своя сильная метка
+	xmlETag := formatETag(etag, formatXML)
+	for idx, c := range []struct {
+		accept, ifNoneMatch, etag string
+		status                    int
+	}{
+		{"application/xml", "", xmlETag, http.StatusOK},
+		{"application/xml", etag, xmlETag, http.StatusOK},
+		{"application/xml", xmlETag, xmlETag, http.StatusNotModified},
+		{"application/msgpack", xmlETag, formatETag(etag, formatMsgPack), http.StatusOK},
+		{"application/json", xmlETag, etag, http.StatusOK},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/items/1", nil)
+		req.Header.Set("Accept", c.accept)
+		if c.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", c.ifNoneMatch)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status || resp.Header.Get("ETag") != c.etag {
+			t.Errorf("representation %d: got %d %s, want %d %s", idx, resp.StatusCode, resp.Header.Get("ETag"), c.status, c.etag)
+		}
+	}
+
+	cases := []Case{
+		Case{ // 0
+			Path:    "/items/1",
+			Method:  http.MethodPost,
+			Body:    CR{"title": "changed"},
+			Headers: map[string]string{"If-Match": `"other"`},
+			Status:  http.StatusPreconditionFailed,
+			Result:  CR{"error": "precondition failed: record has been changed"},
+		},
+		Case{ // 1 - слабая метка при сильном сравнении не подходит
+			Path:    "/items/1",
+			Method:  http.MethodPost,
+			Body:    CR{"title": "changed"},
+			Headers: map[string]string{"If-Match": "W/" + etag},
+			Status:  http.StatusPreconditionFailed,
+			Result:  CR{"error": "precondition failed: record has been changed"},
+		},
+		Case{ // 2 - If-Match сверяется с записью, метка XML-представления тоже подходит
+			Path:    "/items/1",
+			Method:  http.MethodPost,
+			Body:    CR{"title": "changed"},
+			Headers: map[string]string{"If-Match": `"other", ` + xmlETag},
+			Result:  CR{"response": CR{"updated": 1}},
+		},
+		Case{ // 3 - запись уже поменялась
+			Path:    "/items/1",
+			Method:  http.MethodPost,
+			Body:    CR{"title": "lost update"},
+			Headers: map[string]string{"If-Match": etag},
+			Status:  http.StatusPreconditionFailed,
+			Result:  CR{"error": "precondition failed: record has been changed"},
+		},
+		Case{ // 4
+			Path:   "/items/1",
+			Result: CR{"response": CR{"record": changed}},
+		},
+		Case{ // 5
+			Path:    "/items/1",
+			Method:  http.MethodDelete,
+			Headers: map[string]string{"If-Match": etag},
+			Status:  http.StatusPreconditionFailed,
+			Result:  CR{"error": "precondition failed: record has been changed"},
+		},
+		Case{ // 6
+			Path:    "/items/1",
+			Method:  http.MethodDelete,
+			Headers: map[string]string{"If-Match": changedETag},
+			Result:  CR{"response": CR{"deleted": 1}},
+		},
+		Case{ // 7
+			Path:    "/items/2",
+			Method:  http.MethodDelete,
+			Headers: map[string]string{"If-Match": "*"},
+			Result:  CR{"response": CR{"deleted": 1}},
+		},
+		Case{ // 8 - записи нет, * тоже не подходит
+			Path:    "/items/2",
+			Method:  http.MethodDelete,
+			Headers: map[string]string{"If-Match": "*"},
+			Status:  http.StatusPreconditionFailed,
+			Result:  CR{"error": "precondition failed: record has been changed"},
+		},
+		Case{ // 9
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Body:   CR{"info": "changed"},
+			Status: http.StatusPreconditionRequired,
+			Result: CR{"error": "If-Match header is required"},
+		},
+		Case{ // 10
+			Path:   "/users/1",
+			Method: http.MethodDelete,
+			Status: http.StatusPreconditionRequired,
+			Result: CR{"error": "If-Match header is required"},
+		},
+		Case{ // 11 - без заголовка обновляем как раньше
+			Path:   "/items/3",
+			Method: http.MethodPost,
+			Body:   CR{"title": "no such item"},
+			Status: http.StatusNotFound,
+			Result: CR{"error": "sql: no rows in result set"},
+		},
+	}
+
+	runCases(t, ts, db, cases)
+}
+
+func TestConditionalGet(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+	for _, query := range []string{
+		`ALTER TABLE items ADD COLUMN updated_at varchar(32) DEFAULT NULL`,
+		`UPDATE items SET updated_at = '2024-01-02 03:04:05' WHERE id = 1`,
+		`UPDATE items SET updated_at = '2024-03-01 00:00:00' WHERE id = 2`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			panic(err)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.CacheControl = "no-cache"
+	cfg.TableSettings = map[string]TableConfig{
+		"items": {CacheControl: "max-age=60"},
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	get := func(path string, headers map[string]string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get("/items/1", nil)
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("expected 200 with ETag, got %d %q", resp.StatusCode, etag)
+	}
+	if lm := resp.Header.Get("Last-Modified"); lm != "Tue, 02 Jan 2024 03:04:05 GMT" {
+		t.Errorf("bad Last-Modified %q", lm)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "max-age=60" {
+		t.Errorf("bad Cache-Control %q", cc)
+	}
+
+	listResp := get("/items", nil)
+	listETag := listResp.Header.Get("ETag")
+	if listETag == "" || listETag == etag {
+		t.Fatalf("list must have its own ETag, got %q", listETag)
+	}
+	if lm := listResp.Header.Get("Last-Modified"); lm != "Fri, 01 Mar 2024 00:00:00 GMT" {
+		t.Errorf("list Last-Modified must be the latest of rows, got %q", lm)
+	}
+	if cc := get("/users/1", nil).Header.Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("bad default Cache-Control %q", cc)
+	}
+
+	checks := []struct {
+		path    string
+		headers map[string]string
+		status  int
+	}{
+		{"/items/1", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"/items/1", map[string]string{"If-None-Match": "W/" + etag}, http.StatusNotModified},
+		{"/items/1", map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"/items", map[string]string{"If-None-Match": listETag}, http.StatusNotModified},
+		{"/items", map[string]string{"If-None-Match": etag}, http.StatusOK},
+		{"/items/1", map[string]string{"If-Modified-Since": "Tue, 02 Jan 2024 03:04:05 GMT"}, http.StatusNotModified},
+		{"/items/1", map[string]string{"If-Modified-Since": "Tue, 02 Jan 2024 03:04:04 GMT"}, http.StatusOK},
+		// If-None-Match важнее If-Modified-Since
+		{"/items/1", map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": "Tue, 02 Jan 2024 03:04:05 GMT"}, http.StatusOK},
+		// без колонки времени If-Modified-Since не на что опереться
+		{"/users/1", map[string]string{"If-Modified-Since": "Tue, 02 Jan 2024 03:04:05 GMT"}, http.StatusOK},
+	}
+	for i, c := range checks {
+		if resp := get(c.path, c.headers); resp.StatusCode != c.status {
+			t.Errorf("check %d: %s %v: expected %d, got %d", i, c.path, c.headers, c.status, resp.StatusCode)
+		}
+	}
+
+	// после изменения старая метка больше не подходит
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/items/1", strings.NewReader(`{"title": "changed"}`))
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+	if resp := get("/items/1", map[string]string{"If-None-Match": etag}); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 after update, got %d", resp.StatusCode)
+	}
+	if resp := get("/items", map[string]string{"If-None-Match": listETag}); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 for list after update, got %d", resp.StatusCode)
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.Cache = CacheConfig{Size: 100, TTL: time.Minute}
+	cfg.TableSettings = map[string]TableConfig{
+		"users": {CacheTTL: -1},
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	do := func(method, path, body string) (string, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := ioutil.ReadAll(resp.Body)
+		return resp.Header.Get("X-Cache"), string(data)
+	}
+
+	checks := []struct {
+		method, path, body string
+		cache              string
+		contains           string
+	}{
+		{http.MethodGet, "/items/1", "", "MISS", "database/sql"},
+		{http.MethodGet, "/items/1", "", "HIT", "database/sql"},
+		{http.MethodGet, "/items", "", "MISS", "memcache"},
+		{http.MethodGet, "/items", "", "HIT", "memcache"},
+		// другая страница - другой ключ
+		{http.MethodGet, "/items?limit=1", "", "MISS", "database/sql"},
+		{http.MethodGet, "/items/2", "", "MISS", "memcache"},
+		{http.MethodGet, "/items/1?nocache=1", "", "BYPASS", "database/sql"},
+		// изменение через explorer сбрасывает запись и списки
+		{http.MethodPost, "/items/1", `{"title": "cached"}`, "", "updated"},
+		{http.MethodGet, "/items/1", "", "MISS", "cached"},
+		{http.MethodGet, "/items", "", "MISS", "cached"},
+		{http.MethodGet, "/items/2", "", "HIT", "memcache"},
+		{http.MethodDelete, "/items/2", "", "", "deleted"},
+		{http.MethodGet, "/items/2", "", "MISS", "record not found"},
+		{http.MethodGet, "/items/2", "", "MISS", "record not found"},
+		// таблица с отрицательным cache_ttl не кешируется
+		{http.MethodGet, "/users/1", "", "", "rvasily"},
+		{http.MethodGet, "/users/1", "", "", "rvasily"},
+	}
+	for i, c := range checks {
+		cache, body := do(c.method, c.path, c.body)
+		if cache != c.cache || !strings.Contains(body, c.contains) {
+			t.Fatalf("check %d: %s %s: expected X-Cache %q and %q in body, got %q %s", i, c.method, c.path, c.cache, c.contains, cache, body)
+		}
+	}
+
+	// изменение мимо explorer'а не видно до истечения TTL
+	db.Exec(`UPDATE items SET title = 'direct' WHERE id = 1`)
+	if _, body := do(http.MethodGet, "/items/1", ""); strings.Contains(body, "direct") {
+		t.Errorf("direct change must not be visible before ttl")
+	}
+	if _, body := do(http.MethodGet, "/items/1?nocache=1", ""); !strings.Contains(body, "direct") {
+		t.Errorf("nocache must read from the database")
+	}
+
+	_, body := do(http.MethodGet, "/_admin/cache", "")
+	var stats struct {
+		Response map[string]int `json:"response"`
+	}
+	json.Unmarshal([]byte(body), &stats)
+	if stats.Response["hits"] != 4 || stats.Response["misses"] != 8 || stats.Response["capacity"] != 100 {
+		t.Errorf("bad cache stats %s", body)
+	}
+	do(http.MethodDelete, "/_admin/cache", "")
+	if _, body := do(http.MethodGet, "/items/1", ""); !strings.Contains(body, "direct") {
+		t.Errorf("cache must be empty after purge")
+	}
+}
+
+func TestCSVExport(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+	db.Exec(`INSERT INTO items (id, title, description, updated) VALUES (3, 'a, "quoted"', 'two
+lines', NULL)`)
+
+	cfg := DefaultConfig()
+	cfg.CSV.Null = "NULL"
+	cfg.TableSettings = map[string]TableConfig{
+		"users": {WriteOnlyColumns: []string{"password"}},
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	get := func(path, accept string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, body := get("/items", "text/csv")
+	expected := "id,title,description,updated\r\n" +
+		"1,database/sql,Рассказать про базы данных,rvasily\r\n" +
+		"2,memcache,Рассказать про мемкеш с примером использования,NULL\r\n" +
+		"3,\"a, \"\"quoted\"\"\",\"two\r\nlines\",NULL\r\n"
+	if body != expected {
+		t.Errorf("bad csv:\n%q\nwant\n%q", body, expected)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("bad Content-Type %q", ct)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename=items.csv` {
+		t.Errorf("bad Content-Disposition %q", cd)
+	}
+	etag := resp.Header.Get("ETag")
+	if _, jsonBody := get("/items", ""); !strings.HasPrefix(jsonBody, "{") {
+		t.Errorf("json must stay the default, got %s", jsonBody)
+	}
+	if jsonResp, _ := get("/items", ""); jsonResp.Header.Get("ETag") == etag {
+		t.Errorf("csv and json must have different ETags")
+	}
+
+	// ?format важнее Accept, ?null перекрывает настройку, write only колонки не выгружаются
+	_, body = get("/users?format=csv&null=", "application/json")
+	if body != "user_id,login,email,info,updated\r\n1,rvasily,rvasily@example.com,none,\r\n" {
+		t.Errorf("bad users csv %q", body)
+	}
+	_, body = get("/items?format=csv&limit=1&offset=1", "")
+	if body != "id,title,description,updated\r\n2,memcache,Рассказать про мемкеш с примером использования,NULL\r\n" {
+		t.Errorf("csv must respect pagination, got %q", body)
+	}
+
+	if resp, _ := get("/items", "image/png"); resp.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("expected 406, got %d", resp.StatusCode)
+	}
+	if resp, _ := get("/items?format=pdf", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+	tx, _ := db.Begin()
+	for i := 3; i <= 1000; i++ {
+		tx.Exec(`INSERT INTO items (title, description) VALUES ('bulk', '` + strings.Repeat("x", 500) + `')`)
+	}
+	tx.Commit()
+
+	cfg := DefaultConfig()
+	cfg.Pagination.AllowUnbounded = true
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/items?limit=all", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("bad Content-Type %q", ct)
+	}
+	dec := json.NewDecoder(resp.Body)
+	count := 0
+	for {
+		row := CR{}
+		if err := dec.Decode(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("line %d: %v", count+1, err)
+		}
+		count++
+		if count == 1 && (row["title"] != "database/sql" || row["id"] != 1.0) {
+			t.Errorf("bad first row %v", row)
+		}
+	}
+	resp.Body.Close()
+	if count != 1000 {
+		t.Errorf("expected 1000 rows, got %d", count)
+	}
+
+	// пагинация та же, что у обычного списка
+	req, _ = http.NewRequest(http.MethodGet, ts.URL+"/items?format=ndjson&limit=2&offset=1", nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if lines := strings.Split(strings.TrimSpace(string(body)), "\n"); len(lines) != 2 || !strings.Contains(lines[0], "memcache") {
+		t.Errorf("bad page %s", body)
+	}
+
+	// клиент ушёл посреди потока - соединение с базой должно освободиться
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/items?format=ndjson&limit=all", nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bufio.NewReader(resp.Body).ReadString('\n')
+	cancel()
+	resp.Body.Close()
+	for i := 0; db.Stats().InUse > 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if inUse := db.Stats().InUse; inUse > 0 {
+		t.Errorf("%d connections still in use after client disconnect", inUse)
+	}
+
+	// timeouts.query истёк до первой строки - заголовок ещё не отправлен, клиент получает обычную ошибку
+	slowCfg := DefaultConfig()
+	slowCfg.Timeouts.Query = time.Nanosecond
+	slow, err := NewDbExplorerWithConfig(db, nil, slowCfg)
+	if err != nil {
+		panic(err)
+	}
+	rec := httptest.NewRecorder()
+	slow.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items?format=ndjson", nil))
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), `"error"`) {
+		t.Errorf("expired query: got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestNDJSONFirstRowError(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+	dialect, _ := detectDialect(db)
+	if dialect.Name() != "sqlite" {
+		t.Skip("only sqlite stores infinity in a real column")
+	}
+	// +Inf читается из базы, но в JSON не кодируется - ошибка на первой же строке
+	if _, err := db.Exec(`ALTER TABLE items ADD COLUMN score real DEFAULT NULL`); err != nil {
+		panic(err)
+	}
+	db.Exec(`UPDATE items SET score = 1e999`)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/items", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	// заголовок 200 ещё не отправлен, поэтому ошибка - обычный ответ, а не строка после него
+	var result CR
+	if resp.StatusCode != http.StatusInternalServerError || resp.Header.Get("Content-Type") != "application/json" ||
+		json.Unmarshal(body, &result) != nil || !strings.Contains(fmt.Sprint(result["error"]), "unsupported value") {
+		t.Errorf("got %d %s %s", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+}
+
+func TestImport(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.Import.ChunkSize = 2
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	long := strings.Repeat("x", 256)
+	cases := []struct {
+		query, contentType, body string
+		status                   int
+		result                   interface{}
+	}{
+		{ // 0
+			contentType: "text/csv",
+			body:        "title,description,updated\nfirst,\"a, b\",\nsecond,c,someone\nthird,d,\n",
+			result:      CR{"response": CR{"inserted": 3, "failed": 0, "rolled_back": 0, "aborted": false, "ignored_columns": []string{}, "errors": []CR{}}},
+		},
+		{ // 1 - вторая порция откатывается, первая остаётся
+			contentType: "text/csv",
+			body:        "title,description\nfourth,e\nfifth,f\nsixth,g\n" + long + ",h\n",
+			result: CR{"response": CR{"inserted": 2, "failed": 1, "rolled_back": 1, "aborted": true, "ignored_columns": []string{}, "errors": []CR{
+				CR{"line": 5, "error": "field title is too long"},
+			}}},
+		},
+		{ // 2
+			query:       "on_error=skip&null=NULL",
+			contentType: "text/csv; charset=utf-8",
+			body:        "title,description,updated\nseventh,i,NULL\n" + long + ",j,NULL\nbroken,k\neighth,l,\n",
+			result: CR{"response": CR{"inserted": 2, "failed": 2, "rolled_back": 0, "aborted": false, "ignored_columns": []string{}, "errors": []CR{
+				CR{"line": 3, "error": "field title is too long"},
+				CR{"line": 4, "error": "expected 3 fields, got 2"},
+			}}},
+		},
+		{ // 3
+			query:       "on_error=skip",
+			contentType: "application/x-ndjson",
+			body:        `{"title": "ninth", "description": "m"}` + "\nnot json\n" + `{"title": 5}` + "\n\n" + `{"title": "tenth", "description": "n", "updated": null}` + "\n",
+			result: CR{"response": CR{"inserted": 2, "failed": 2, "rolled_back": 0, "aborted": false, "ignored_columns": []string{}, "errors": []CR{
+				CR{"line": 2, "error": "invalid character 'o' in literal null (expecting 'u')"},
+				CR{"line": 3, "error": "field title have invalid type"},
+			}}},
+		},
+		{ // 4
+			contentType: "text/csv",
+			body:        "title,color\nx,red\n",
+			status:      http.StatusBadRequest,
+			result:      CR{"error": "unknown column color"},
+		},
+		{ // 5
+			contentType: "application/json",
+			body:        `[{"title": "x"}]`,
+			status:      http.StatusUnsupportedMediaType,
+			result:      CR{"error": "unsupported content type, text/csv or application/x-ndjson expected"},
+		},
+		{ // 6
+			query:       "on_error=ignore",
+			contentType: "text/csv",
+			body:        "title\nx\n",
+			status:      http.StatusBadRequest,
+			result:      CR{"error": "bad on_error value, abort or skip expected"},
+		},
+		{ // 7 - явно пустой ?null= делает пустые поля NULL, ключ из файла не вставляется
+			query:       "null=",
+			contentType: "text/csv",
+			body:        "id,title,description,updated\n100,eleventh,o,\n",
+			result:      CR{"response": CR{"inserted": 1, "failed": 0, "rolled_back": 0, "aborted": false, "ignored_columns": []string{"id"}, "errors": []CR{}}},
+		},
+	}
+	for idx, c := range cases {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/items/_import?"+c.query, strings.NewReader(c.body))
+		req.Header.Set("Content-Type", c.contentType)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result, expected interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		data, _ := json.Marshal(c.result)
+		json.Unmarshal(data, &expected)
+		if c.status == 0 {
+			c.status = http.StatusOK
+		}
+		if resp.StatusCode != c.status || !reflect.DeepEqual(result, expected) {
+			t.Errorf("case %d: got %d %#v\nwant %d %#v", idx, resp.StatusCode, result, c.status, expected)
+		}
+	}
+
+	rows, err := db.Query(`SELECT title FROM items WHERE id > 2 ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	titles := make([]string, 0)
+	for rows.Next() {
+		var title string
+		rows.Scan(&title)
+		titles = append(titles, title)
+	}
+	rows.Close()
+	expected := []string{"first", "second", "third", "fourth", "fifth", "seventh", "eighth", "ninth", "tenth", "eleventh"}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("bad imported rows %v", titles)
+	}
+	var updated sql.NullString
+	db.QueryRow(`SELECT updated FROM items WHERE title = 'first'`).Scan(&updated)
+	if !updated.Valid || updated.String != "" {
+		t.Errorf("empty csv value without null token must be imported as empty string, got %#v", updated)
+	}
+	db.QueryRow(`SELECT updated FROM items WHERE title = 'eleventh'`).Scan(&updated)
+	if updated.Valid {
+		t.Errorf("empty csv value with ?null= must be imported as NULL, got %q", updated.String)
+	}
+	var id int
+	db.QueryRow(`SELECT id FROM items WHERE title = 'eleventh'`).Scan(&id)
+	if id == 100 {
+		t.Errorf("primary key from csv must not be imported")
+	}
+}
+
+func TestDump(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+	dialect, err := detectDialect(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Dump.BatchSize = 2
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	tricky := "it's \"quoted\"\nback\\slash\x1a"
+	if _, err := db.Exec(`INSERT INTO items (id, title, description, updated) VALUES (3, 'dump', `+dialect.Placeholder(1)+`, NULL)`, tricky); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) (*http.Response, string) {
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, script := get("/items/_dump")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/sql; charset=utf-8" {
+		t.Fatalf("bad dump response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != `attachment; filename=items.sql` {
+		t.Errorf("bad Content-Disposition %q", cd)
+	}
+	items := dialect.QuoteIdent("items")
+	for _, part := range []string{
+		"DROP TABLE IF EXISTS " + items + ";\nCREATE TABLE",
+		"INSERT INTO " + items + " (",
+		"-- Dump completed on ",
+	} {
+		if !strings.Contains(script, part) {
+			t.Errorf("dump has no %q:\n%s", part, script)
+		}
+	}
+	// три записи по две в INSERT
+	if n := strings.Count(script, "INSERT INTO"); n != 2 {
+		t.Errorf("expected 2 INSERT statements, got %d:\n%s", n, script)
+	}
+	if strings.Contains(script, dialect.QuoteIdent("users")) {
+		t.Errorf("table dump contains other tables:\n%s", script)
+	}
+
+	// дамп восстанавливает таблицу в том виде, в каком она была
+	if dialect.Name() == "sqlite" {
+		if _, err := db.Exec(`DELETE FROM items WHERE id = 1; UPDATE items SET title = 'changed'`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(script); err != nil {
+			t.Fatalf("can't restore dump: %s\n%s", err, script)
+		}
+		var description string
+		if err := db.QueryRow(`SELECT description FROM items WHERE id = 3`).Scan(&description); err != nil || description != tricky {
+			t.Errorf("bad restored description %q, %v", description, err)
+		}
+		var count int
+		db.QueryRow(`SELECT COUNT(*) FROM items WHERE title <> 'changed' AND (id <> 2 OR updated IS NULL)`).Scan(&count)
+		if count != 3 {
+			t.Errorf("expected 3 restored rows, got %d", count)
+		}
+	}
+
+	resp, script = get("/_dump")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad dump status %d", resp.StatusCode)
+	}
+	itemsPos := strings.Index(script, "Table structure for table "+items)
+	usersPos := strings.Index(script, "Table structure for table "+dialect.QuoteIdent("users"))
+	if itemsPos == -1 || usersPos == -1 || itemsPos > usersPos {
+		t.Errorf("dump must contain all tables in alphabetical order:\n%s", script)
+	}
+	if !strings.Contains(script, "-- Dump completed on ") {
+		t.Errorf("dump is not completed:\n%s", script)
+	}
+
+	resp, body := get("/unknown/_dump")
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "unknown table") {
+		t.Errorf("unknown table: got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestDumpPolicy(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+	dialect, err := detectDialect(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Auth = AuthConfig{
+		APIKeys: []APIKeyConfig{
+			{Label: "ci", Hash: hashAPIKey("admin-key")},
+			{Label: "reports", Hash: hashAPIKey("analyst-key")},
+			{Label: "ops", Hash: hashAPIKey("operator-key")},
+		},
+		PolicyFile: writeTestPolicy(t, `
+bindings:
+  ci: [admin]
+  reports: [analyst]
+  ops: [operator]
+roles:
+  admin:
+    - tables: ["*", _dump]
+      methods: ["*"]
+  analyst:
+    - tables: ["*", _dump]
+      methods: [GET]
+    - tables: [users]
+      methods: ["*"]
+      deny: true
+  operator:
+    - tables: ["*"]
+      methods: ["*"]
+`),
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	get := func(path, key string) (*http.Response, string) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("X-API-Key", key)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	users := dialect.QuoteIdent("users")
+	resp, script := get("/_dump", "analyst-key")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("bad dump status %d: %s", resp.StatusCode, script)
+	}
+	if strings.Contains(script, "INSERT INTO "+users) || strings.Contains(script, "DROP TABLE IF EXISTS "+users) {
+		t.Errorf("denied table users is in the dump:\n%s", script)
+	}
+	if !strings.Contains(script, "-- Skipped: access denied: table users is not readable\n") {
+		t.Errorf("dump does not mention skipped users:\n%s", script)
+	}
+	if !strings.Contains(script, "INSERT INTO "+dialect.QuoteIdent("items")) {
+		t.Errorf("dump has no items:\n%s", script)
+	}
+
+	resp, body := get("/users/_dump", "analyst-key")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("users dump for analyst: got %d %s", resp.StatusCode, body)
+	}
+
+	// "*" не открывает служебные маршруты
+	for _, path := range []string{"/_dump", "/_audit"} {
+		resp, body = get(path, "operator-key")
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s for operator: got %d %s", path, resp.StatusCode, body)
+		}
+	}
+
+	_, script = get("/_dump", "admin-key")
+	if !strings.Contains(script, "INSERT INTO "+users) {
+		t.Errorf("admin dump has no users:\n%s", script)
+	}
+}
+
+func TestDumpRestrictedColumns(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	cfg := DefaultConfig()
+	cfg.TableSettings = map[string]TableConfig{
+		"users": {WriteOnlyColumns: []string{"password"}},
+		// CREATE TABLE из базы содержит скрытую колонку, а INSERT'ы - нет, такой дамп не восстановить
+		"items": {HiddenColumns: []string{"description"}},
+	}
+	handler, err := NewDbExplorerWithConfig(db, nil, cfg)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	resp, err := client.Get(ts.URL + "/items/_dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "access denied: dump of items would omit its hidden columns") {
+		t.Errorf("hidden columns dump: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, err = client.Get(ts.URL + "/users/_dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "access denied: dump of users would include write only column password") {
+		t.Errorf("write only dump: got %d %s", resp.StatusCode, body)
+	}
+
+	resp, err = client.Get(ts.URL + "/_dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(body), "love") || !strings.Contains(string(body), "-- Skipped: access denied: dump of users would include write only column password") {
+		t.Errorf("write only column leaked or not reported:\n%s", body)
+	}
+	if strings.Contains(string(body), "description") || !strings.Contains(string(body), "-- Skipped: access denied: dump of items would omit its hidden columns") {
+		t.Errorf("hidden column leaked or not reported:\n%s", body)
+	}
+}
+
+func TestResponseEncodings(t *testing.T) {
+	db := openTestDB()
+	PrepareTestApis(db)
+
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		panic(err)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	send := func(method, path, accept string) (*http.Response, []byte) {
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	xmlPrefix := xml.Header + `<response xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">`
+	cases := []struct {
+		method, path, accept string
+		status               int
+		contentType          string
+		body                 string
+	}{
+		{ // 0
+			path:        "/items/2",
+			accept:      "application/xml",
+			contentType: "application/xml; charset=utf-8",
+			body: xmlPrefix + `
+   <record>
+      <description>Рассказать про мемкеш с примером использования</description>
+      <id>2</id>
+      <title>memcache</title>
+      <updated xsi:nil="true"></updated>
+   </record>
+</response>`,
+		},
+		{ // 1
+			path:        "/items?limit=1",
+			accept:      "text/xml",
+			contentType: "application/xml; charset=utf-8",
+			body: xmlPrefix + `
+   <records>
+      <item>
+         <description>Рассказать про базы данных</description>
+         <id>1</id>
+         <title>database/sql</title>
+         <updated>rvasily</updated>
+      </item>
+   </records>
+</response>`,
+		},
+		{ // 2
+			path:        "/items/100500?format=xml",
+			status:      http.StatusNotFound,
+			contentType: "application/xml; charset=utf-8",
+			body:        xml.Header + `<error xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">record not found</error>`,
+		},
+		{ // 3
+			method:      http.MethodDelete,
+			path:        "/unknown_table/1",
+			accept:      "application/xml",
+			status:      http.StatusNotFound,
+			contentType: "application/xml; charset=utf-8",
+			body:        xml.Header + `<error xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">unknown table</error>`,
+		},
+		{ // 4
+			path:        "/items/2",
+			accept:      "application/x-msgpack",
+			contentType: "application/msgpack",
+		},
+		{ // 5 - CSV обработчик разбирает сам, ошибку отдаём в JSON
+			path:        "/items?limit=-1",
+			accept:      "text/csv",
+			status:      http.StatusBadRequest,
+			contentType: "application/json",
+			body:        "{\n   \"error\": \"bad limit value\"\n}",
+		},
+		{ // 6 - записи отвечают на неподходящий Accept так же, как список
+			path:        "/items/1",
+			accept:      "image/png",
+			status:      http.StatusNotAcceptable,
+			contentType: "application/json",
+			body:        "{\n   \"error\": \"none of the accepted media types is supported\"\n}",
+		},
+		{ // 7
+			path:        "/items",
+			accept:      "image/png",
+			status:      http.StatusNotAcceptable,
+			contentType: "application/json",
+			body:        "{\n   \"error\": \"none of the accepted media types is supported\"\n}",
+		},
+		{ // 8 - неизвестный ?format отвергается на всех маршрутах, как у списка
+			path:        "/items/1?format=bogus",
+			status:      http.StatusBadRequest,
+			contentType: "application/json",
+			body:        "{\n   \"error\": \"unsupported format bogus\"\n}",
+		},
+		{ // 9 - CSV отдаёт только список
+			path:        "/items/1?format=csv",
+			status:      http.StatusBadRequest,
+			contentType: "application/json",
+			body:        "{\n   \"error\": \"unsupported format csv\"\n}",
+		},
+		{ // 10
+			method:      http.MethodPost,
+			path:        "/items/1?format=ndjson",
+			status:      http.StatusBadRequest,
+			contentType: "application/json",
+			body:        "{\n   \"error\": \"unsupported format ndjson\"\n}",
+		},
+		{ // 11 - спецификация бывает только в JSON, а ошибка - в любом из форматов ответа
+			path:        "/_openapi.json?format=xml",
+			status:      http.StatusBadRequest,
+			contentType: "application/xml; charset=utf-8",
+			body:        xml.Header + `<error xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">unsupported format xml</error>`,
+		},
+		{ // 12
+			path:        "/items/_jsonschema",
+			accept:      "application/xml",
+			status:      http.StatusNotAcceptable,
+			contentType: "application/xml; charset=utf-8",
+			body:        xml.Header + `<error xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">none of the accepted media types is supported</error>`,
+		},
+		{ // 13
+			path:        "/items/_dump?format=json",
+			status:      http.StatusBadRequest,
+			contentType: "application/json",
+			body:        "{\n   \"error\": \"unsupported format json\"\n}",
+		},
+		{ // 14
+			path:        "/items/_dump?format=sql",
+			contentType: "application/sql; charset=utf-8",
+		},
+	}
+	for idx, c := range cases {
+		if c.method == "" {
+			c.method = http.MethodGet
+		}
+		if c.status == 0 {
+			c.status = http.StatusOK
+		}
+		resp, body := send(c.method, c.path, c.accept)
+		if resp.StatusCode != c.status || resp.Header.Get("Content-Type") != c.contentType {
+			t.Errorf("case %d: got %d %s, want %d %s", idx, resp.StatusCode, resp.Header.Get("Content-Type"), c.status, c.contentType)
+		}
+		if c.body != "" && string(body) != c.body {
+			t.Errorf("case %d: bad body\n%s\nwant\n%s", idx, body, c.body)
+		}
+		if !containsString(resp.Header.Values("Vary"), "Accept") {
+			t.Errorf("case %d: no Vary: Accept, got %v", idx, resp.Header.Values("Vary"))
+		}
+	}
+
+	// map{"response": map{"record": map{...}}}, ключи по алфавиту; описание длиннее 31 байта - str8
+	_, body := send(http.MethodGet, "/items/2", "application/msgpack")
+	expected := []byte("\x81\xa8response\x81\xa6record\x84" +
+		"\xabdescription\xd9\x57Рассказать про мемкеш с примером использования" +
+		"\xa2id\x02" +
+		"\xa5title\xa8memcache" +
+		"\xa7updated\xc0")
+	if !bytes.Equal(body, expected) {
+		t.Errorf("bad msgpack body %x\nwant %x", body, expected)
+	}
+}
+
+func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
+	for idx, item := range cases {
+		var (
+			err      error
+			result   interface{}
+			expected interface{}
+			req      *http.Request
+		)
+
+		caseName := fmt.Sprintf("case %d: [%s] %s %s", idx, item.Method, item.Path, item.Query)
+
+		// если у вас случилась это ошибка - значит вы не делаете где-то rows.Close и у вас текут соединения с базой
+		// если такое случилось на первом тесте - значит вы не закрываете коннект где-то при иницаилизации в NewDbExplorer
+		if db.Stats().OpenConnections != 1 {
+			t.Fatalf("[%s] you have %d open connections, must be 1", caseName, db.Stats().OpenConnections)
+		}
+
+		if item.Method == "" || item.Method == http.MethodGet {
+			req, err = http.NewRequest(item.Method, ts.URL+item.Path+"?"+item.Query, nil)
+		} else {
+			data, err := json.Marshal(item.Body)
+			if err != nil {
+				panic(err)
+			}
+			reqBody := bytes.NewReader(data)
+			req, err = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
+			req.Header.Add("Content-Type", "application/json")
+		}
+		for k, v := range item.Headers {
+			req.Header.Set(k, v)
 		}
 
 		resp, err := client.Do(req)
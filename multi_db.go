@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// NamedDB - одна из баз, которые обслуживает MultiDbExplorer
+type NamedDB struct {
+	Name    string
+	Db      *sql.DB
+	Dialect Dialect
+	// ReadDb - необязательное подключение для GET запросов
+	ReadDb *sql.DB
+}
+
+// MultiDbExplorer раздаёт несколько баз сразу: GET / возвращает список баз,
+// а всё, что лежит под /db/$database/, уходит в DbExplorer этой базы как есть.
+// У каждой базы своя TablesInfo, перечитывается она независимо от остальных
+type MultiDbExplorer struct {
+	Logger    *log.Logger
+	Explorers map[string]*DbExplorer
+	names     []string
+}
+
+func NewMultiDbExplorer(dbs []NamedDB) (*MultiDbExplorer, error) {
+	return NewMultiDbExplorerWithConfig(dbs, DefaultConfig())
+}
+
+func NewMultiDbExplorerWithConfig(dbs []NamedDB, cfg *Config) (*MultiDbExplorer, error) {
+	if len(dbs) == 0 {
+		return nil, errors.New("no databases")
+	}
+	if err := cfg.OpenFiles(); err != nil {
+		return nil, err
+	}
+	multi := MultiDbExplorer{
+		Logger:    cfg.Log.Logger(),
+		Explorers: make(map[string]*DbExplorer),
+		names:     make([]string, 0, len(dbs)),
+	}
+	for _, namedDb := range dbs {
+		if namedDb.Name == "" || strings.Contains(namedDb.Name, "/") {
+			return nil, fmt.Errorf("bad database name %q", namedDb.Name)
+		}
+		if _, exists := multi.Explorers[namedDb.Name]; exists {
+			return nil, fmt.Errorf("database %s declared twice", namedDb.Name)
+		}
+		explorer, err := NewDbExplorerWithConfig(namedDb.Db, namedDb.Dialect, cfg.forDatabase(namedDb.Name))
+		if err != nil {
+			return nil, fmt.Errorf("database %s: %w", namedDb.Name, err)
+		}
+		explorer.Name = namedDb.Name
+		explorer.ReadDb = namedDb.ReadDb
+		multi.Explorers[namedDb.Name] = explorer
+		multi.names = append(multi.names, namedDb.Name)
+	}
+	return &multi, nil
+}
+
+func (m *MultiDbExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w = withEncoder(w, r)
+	if r.URL.Path == "/" {
+		if !m.authenticate(w, r) {
+			return
+		}
+		if _, err := negotiateFormat(r, responseFormats...); err != nil {
+			sendFormatError(w, err)
+			return
+		}
+		if r.Method != http.MethodGet {
+			sendErrResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		resp := map[string]interface{}{"databases": m.names}
+		sendResponse(w, map[string]interface{}{"response": resp}, http.StatusOK)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/db/") {
+		sendErrResponse(w, "not found", http.StatusNotFound)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/db/")
+	name, path := rest, "/"
+	if slashPos := strings.Index(rest, "/"); slashPos != -1 {
+		name, path = rest[:slashPos], rest[slashPos:]
+	}
+	explorer, exists := m.Explorers[name]
+	if !exists {
+		sendErrResponse(w, "unknown database", http.StatusNotFound)
+		return
+	}
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = path
+	r2.URL.RawPath = ""
+	explorer.ServeHTTP(w, r2)
+}
+
+// Reload перечитывает схемы всех баз, ошибка одной базы не мешает остальным
+func (m *MultiDbExplorer) Reload() error {
+	errs := make([]error, 0)
+	for _, name := range m.names {
+		err := m.Explorers[name].Reload()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("database %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ReloadOnSignal перечитывает схемы всех баз при получении сигнала
+func (m *MultiDbExplorer) ReloadOnSignal(ctx context.Context, sig ...os.Signal) {
+	for _, name := range m.names {
+		go m.Explorers[name].ReloadOnSignal(ctx, sig...)
+	}
+	<-ctx.Done()
+}
+
+// WatchSchema следит за схемой каждой базы отдельно
+func (m *MultiDbExplorer) WatchSchema(ctx context.Context, interval time.Duration) {
+	for _, name := range m.names {
+		go m.Explorers[name].WatchSchema(ctx, interval)
+	}
+	<-ctx.Done()
+}
+
+// authenticate нужен только для списка баз, запросы к самим базам проверяет их DbExplorer.
+// Ключ подходит, если его принимает хотя бы одна из баз
+func (m *MultiDbExplorer) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	var lastErr error
+	for _, name := range m.names {
+		explorer := m.Explorers[name]
+		if !explorer.authEnabled() {
+			return true
+		}
+		_, err := explorer.authenticate(r)
+		if err == nil {
+			return true
+		}
+		lastErr = err
+	}
+	m.Logger.Printf("%s %s from %s: %s", r.Method, r.URL.Path, r.RemoteAddr, lastErr)
+	sendUnauthorized(w, lastErr.Error())
+	return false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// NDJSON - список построчно: по JSON-объекту записи на строку, без обёртки {"response": ...}.
+// Записи пишутся по мере чтения из базы и сбрасываются клиенту каждые ndjsonFlushRows строк,
+// так что память не зависит от размера таблицы. Размер потока ограничен limit так же, как у обычного списка.
+// Кеш и ETag для потока не используются.
+// Ошибка до первой строки отдаётся обычным ответом с кодом, после - последней строкой {"error": ...}
+
+const ndjsonFlushRows = 100
+
+func (e *DbExplorer) streamNDJSON(w http.ResponseWriter, r *http.Request, tableInfo *TableInfo, limit, offset int64) {
+	ctx := r.Context()
+	query, args, err := e.listQuery(ctx, tableInfo, limit, offset)
+	if err != nil {
+		e.logRequest(r, err)
+		sendErrResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rc := http.NewResponseController(w)
+	written := 0
+	// headerSent - 200 уже отправлен, после этого ошибку можно отдать только строкой потока
+	headerSent := false
+	sendHeader := func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		headerSent = true
+	}
+	flush := func() {
+		// у потока нет общего срока: write timeout сервера отсчитывается заново для каждой порции
+		if e.Config.Timeouts.Write > 0 {
+			rc.SetWriteDeadline(time.Now().Add(e.Config.Timeouts.Write))
+		}
+		rc.Flush()
+	}
+	err = e.eachRow(ctx, tableInfo, query, args, func(row map[string]interface{}) error {
+		// клиент ушёл - дальше не читаем, QueryContext отменится тем же контекстом
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		line, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		// заголовок отправляем только с первой готовой строкой, чтобы ошибка до неё ушла обычным ответом
+		if !headerSent {
+			sendHeader()
+		}
+		if _, err = w.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		written++
+		if written%ndjsonFlushRows == 0 {
+			flush()
+		}
+		return nil
+	})
+	switch {
+	case err != nil && !headerSent:
+		// ошибку (в том числе истёкший timeouts.query) отдаём обычным ответом
+		e.logRequest(r, err)
+		sendErrResponse(w, err.Error(), http.StatusInternalServerError)
+	case err != nil && ctx.Err() != nil:
+		e.logRequest(r, "ndjson stream interrupted after", written, "rows:", err)
+	case err != nil:
+		e.logRequest(r, err)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+	case !headerSent:
+		sendHeader()
+	default:
+		flush()
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// спецификация строится целиком из TablesInfo, поэтому для новой таблицы ничего дописывать не нужно
+
+func (ct columnType) jsonSchema() map[string]interface{} {
+	schema := make(map[string]interface{})
+	switch ct.Kind {
+	case kindString:
+		schema["type"] = "string"
+		if ct.MaxLength > 0 {
+			schema["maxLength"] = ct.MaxLength
+		}
+		if len(ct.Enum) > 0 {
+			schema["enum"] = ct.Enum
+		}
+	case kindInteger:
+		schema["type"] = "integer"
+		schema["format"] = "int64"
+		if ct.Unsigned {
+			schema["minimum"] = 0
+		}
+	case kindFloat:
+		schema["type"] = "number"
+		schema["format"] = "double"
+		if ct.Unsigned {
+			schema["minimum"] = 0
+		}
+	case kindBool:
+		schema["type"] = "boolean"
+	}
+	return schema
+}
+
+func openAPIFieldSchema(fldInfo *FieldInfo) map[string]interface{} {
+	ct := fldInfo.columnType()
+	schema := ct.jsonSchema()
+	if ct.Nullable {
+		schema["nullable"] = true
+	}
+	if fldInfo.Comment != "" {
+		schema["description"] = fldInfo.Comment
+	}
+	return schema
+}
+
+func openAPIRecordSchema(tableInfo *TableInfo) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	for _, fldInfo := range tableInfo.Fields {
+		if fldInfo.WriteOnly {
+			continue
+		}
+		properties[fldInfo.Field] = openAPIFieldSchema(fldInfo)
+		required = append(required, fldInfo.Field)
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// openAPICreateSchema описывает тело PUT /$table: первичный ключ при вставке игнорируется, read only поля
+// и колонку мягкого удаления задавать нельзя, пропущенные поля заполняются значениями по умолчанию
+func openAPICreateSchema(tableInfo *TableInfo) map[string]interface{} {
+	return openAPIInputSchema(tableInfo, func(fldInfo *FieldInfo) bool {
+		return tableInfo.SoftDelete == nil || tableInfo.SoftDelete.Column != fldInfo.Field
+	})
+}
+
+// openAPIUpdateSchema описывает тело POST /$table/$id: первичный ключ и read only поля обновлять нельзя,
+// пропущенные поля не меняются
+func openAPIUpdateSchema(tableInfo *TableInfo) map[string]interface{} {
+	return openAPIInputSchema(tableInfo, func(*FieldInfo) bool { return true })
+}
+
+// openAPIInputSchema - поля, которые можно передать в теле, кроме первичного ключа и read only.
+// Сервер пропускает неизвестные поля, но в спецификации их нет: клиенту передавать их незачем
+func openAPIInputSchema(tableInfo *TableInfo, writable func(fldInfo *FieldInfo) bool) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for _, fldInfo := range tableInfo.Fields {
+		if fldInfo.Key == "PRI" || fldInfo.ReadOnly || !writable(fldInfo) {
+			continue
+		}
+		properties[fldInfo.Field] = openAPIFieldSchema(fldInfo)
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func openAPISchemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+func openAPIEnvelope(content map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"response": map[string]interface{}{
+				"type":       "object",
+				"properties": content,
+			},
+		},
+	}
+}
+
+func openAPIJSONContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// openAPIResponse - ответ описывается одной схемой для всех кодировщиков из encoding.go
+func openAPIResponse(description string, schema map[string]interface{}) map[string]interface{} {
+	content := make(map[string]interface{}, len(responseEncoders))
+	for format := range responseEncoders {
+		content[formatMediaTypes[format]] = map[string]interface{}{"schema": schema}
+	}
+	return map[string]interface{}{
+		"description": description,
+		"content":     content,
+	}
+}
+
+func openAPIErrorResponse(description string) map[string]interface{} {
+	return openAPIResponse(description, openAPISchemaRef("Error"))
+}
+
+func openAPIQueryParam(name, description string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"in":          "query",
+		"description": description,
+		"required":    false,
+		"schema":      schema,
+	}
+}
+
+// openAPILimitSchema - limit это число или all, если для таблицы разрешены запросы без ограничения
+func openAPILimitSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "integer", "minimum": 0},
+			map[string]interface{}{"type": "string", "enum": []string{"all"}},
+		},
+	}
+}
+
+// buildOpenAPISpec описывает таблицы так, как их видит автор запроса: недоступные для чтения таблицы
+// и закрытые политикой колонки в спецификацию не попадают, как и в GET / и в ответы
+func (e *DbExplorer) buildOpenAPISpec(r *http.Request) map[string]interface{} {
+	allTables := e.tables()
+	tableNames := make([]string, 0, len(allTables))
+	for name := range allTables {
+		tableNames = append(tableNames, name)
+	}
+	sort.Strings(tableNames)
+	tableNames = e.visibleTables(r, tableNames)
+	tablesInfo := make(map[string]*TableInfo, len(tableNames))
+	principal := principalFromContext(r.Context())
+	for _, name := range tableNames {
+		tablesInfo[name] = allTables[name]
+		if e.policy != nil && principal != nil {
+			decision := e.policy.authorize(e.policy.rolesOf(principal), name, http.MethodGet)
+			tablesInfo[name] = restrictTable(allTables[name], decision)
+		}
+	}
+
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+			"required":   []string{"error"},
+		},
+	}
+	paths := map[string]interface{}{
+		"/": map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary":     "list of tables",
+				"operationId": "listTables",
+				"responses": map[string]interface{}{
+					"200": openAPIResponse("tables", openAPIEnvelope(map[string]interface{}{
+						"tables": map[string]interface{}{
+							"type":  "array",
+							"items": map[string]interface{}{"type": "string", "enum": tableNames},
+						},
+					})),
+					"500": openAPIErrorResponse("internal error"),
+				},
+			},
+		},
+	}
+
+	for _, name := range tableNames {
+		tableInfo := tablesInfo[name]
+		recordName := name + "_record"
+		createName := name + "_create"
+		updateName := name + "_update"
+		schemas[recordName] = openAPIRecordSchema(tableInfo)
+		schemas[createName] = openAPICreateSchema(tableInfo)
+		schemas[updateName] = openAPIUpdateSchema(tableInfo)
+
+		pkName := "id"
+		if pk := tableInfo.findPrimKeyName(); pk != nil {
+			pkName = *pk
+		}
+		idParam := map[string]interface{}{
+			"name":        "id",
+			"in":          "path",
+			"description": "value of primary key " + pkName,
+			"required":    true,
+			"schema":      map[string]interface{}{"type": "integer", "format": "int64"},
+		}
+		requestBody := func(schemaName string) map[string]interface{} {
+			return map[string]interface{}{
+				"required": true,
+				"content":  openAPIJSONContent(openAPISchemaRef(schemaName)),
+			}
+		}
+
+		paths["/"+name] = map[string]interface{}{
+			"get": map[string]interface{}{
+				"summary":     "list of records from " + name,
+				"operationId": "list_" + name,
+				"parameters": []interface{}{
+					openAPIQueryParam("limit", "max count of records, all - every record if unbounded queries are allowed", openAPILimitSchema()),
+					openAPIQueryParam("offset", "count of records to skip, can't be used with limit=all", map[string]interface{}{"type": "integer", "minimum": 0}),
+				},
+				"responses": map[string]interface{}{
+					"200": openAPIResponse("records", openAPIEnvelope(map[string]interface{}{
+						"records": map[string]interface{}{"type": "array", "items": openAPISchemaRef(recordName)},
+					})),
+					"400": openAPIErrorResponse("bad limit or offset value, or unbounded queries are not allowed"),
+					"404": openAPIErrorResponse("unknown table"),
+					"500": openAPIErrorResponse("internal error"),
+				},
+			},
+			"put": map[string]interface{}{
+				"summary":     "create record in " + name,
+				"operationId": "create_" + name,
+				"requestBody": requestBody(createName),
+				"responses": map[string]interface{}{
+					"200": openAPIResponse("primary key of created record", openAPIEnvelope(map[string]interface{}{
+						pkName: map[string]interface{}{"type": "integer", "format": "int64"},
+					})),
+					"400": openAPIErrorResponse("field have invalid type"),
+					"404": openAPIErrorResponse("unknown table"),
+					"500": openAPIErrorResponse("internal error"),
+				},
+			},
+		}
+		paths["/"+name+"/{id}"] = map[string]interface{}{
+			"parameters": []interface{}{idParam},
+			"get": map[string]interface{}{
+				"summary":     "record from " + name + " by " + pkName,
+				"operationId": "get_" + name,
+				"responses": map[string]interface{}{
+					"200": openAPIResponse("record", openAPIEnvelope(map[string]interface{}{
+						"record": openAPISchemaRef(recordName),
+					})),
+					"400": openAPIErrorResponse("bad id value"),
+					"404": openAPIErrorResponse("unknown table or record not found"),
+					"500": openAPIErrorResponse("internal error"),
+				},
+			},
+			"post": map[string]interface{}{
+				"summary":     "update record in " + name,
+				"operationId": "update_" + name,
+				"requestBody": requestBody(updateName),
+				"responses": map[string]interface{}{
+					"200": openAPIResponse("count of updated records", openAPIEnvelope(map[string]interface{}{
+						"updated": map[string]interface{}{"type": "integer"},
+					})),
+					"400": openAPIErrorResponse("field have invalid type"),
+					"404": openAPIErrorResponse("unknown table or record not found"),
+					"500": openAPIErrorResponse("internal error"),
+				},
+			},
+			"delete": map[string]interface{}{
+				"summary":     "delete record from " + name,
+				"operationId": "delete_" + name,
+				"responses": map[string]interface{}{
+					"200": openAPIResponse("count of deleted records", openAPIEnvelope(map[string]interface{}{
+						"deleted": map[string]interface{}{"type": "integer"},
+					})),
+					"400": openAPIErrorResponse("bad id value"),
+					"404": openAPIErrorResponse("unknown table"),
+					"500": openAPIErrorResponse("internal error"),
+				},
+			},
+		}
+	}
+
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "db_explorer",
+			"version": "1.0.0",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": schemas},
+	}
+	if e.Name != "" {
+		// в MultiDbExplorer пути базы лежат под /db/$name, а спецификация описывает их без префикса
+		spec["info"].(map[string]interface{})["title"] = "db_explorer: " + e.Name
+		spec["servers"] = []interface{}{
+			map[string]interface{}{"url": e.basePath(), "description": "database " + e.Name},
+		}
+	}
+	return spec
+}
+
+// basePath - префикс, под которым MultiDbExplorer отдаёт пути этой базы, у отдельного explorer'а пустой
+func (e *DbExplorer) basePath() string {
+	if e.Name == "" {
+		return ""
+	}
+	return "/db/" + e.Name
+}
+
+func (e *DbExplorer) handlerOpenAPI(w http.ResponseWriter, r *http.Request) {
+	sendJSONResponse(w, e.buildOpenAPISpec(r), http.StatusOK)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy - файл с ролями. Роль - список правил, правило разрешает (или запрещает, если deny)
+// методы над таблицами и, если задан columns, только над этими колонками.
+// Всё, что не разрешено явно, запрещено; запрещающее правило сильнее разрешающего.
+// Роли JWT берутся из claim RolesClaim, роли API-ключей - из Bindings по метке ключа
+type Policy struct {
+	RolesClaim string                  `yaml:"roles_claim"`
+	Bindings   map[string][]string     `yaml:"bindings"`
+	Roles      map[string][]PolicyRule `yaml:"roles"`
+}
+
+type PolicyRule struct {
+	// Tables и Methods - имена или шаблоны path.Match, "*" - все. Служебные маршруты _admin, _audit и _dump
+	// шаблонам не подчиняются, их нужно перечислить явно
+	Tables  []string `yaml:"tables"`
+	Methods []string `yaml:"methods"`
+	// Columns - какие колонки можно читать и передавать в теле, пустой список - все
+	Columns []string `yaml:"columns"`
+	Deny    bool     `yaml:"deny"`
+}
+
+// accessDecision - результат проверки, Columns nil означает доступ ко всем колонкам
+type accessDecision struct {
+	Allowed bool
+	Columns []string
+}
+
+func loadPolicy(filePath string) (*Policy, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("policy: %w", err)
+	}
+	policy := &Policy{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(policy)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("policy %s: %w", filePath, err)
+	}
+	if policy.RolesClaim == "" {
+		policy.RolesClaim = "roles"
+	}
+	return policy, policy.validate()
+}
+
+func (p *Policy) validate() error {
+	errs := make([]error, 0)
+	for role, rules := range p.Roles {
+		for i, rule := range rules {
+			if len(rule.Tables) == 0 || len(rule.Methods) == 0 {
+				errs = append(errs, fmt.Errorf("policy: roles.%s[%d]: tables and methods are required", role, i))
+			}
+			if rule.Deny && len(rule.Columns) > 0 {
+				errs = append(errs, fmt.Errorf("policy: roles.%s[%d]: deny rule can't have columns", role, i))
+			}
+			for _, pattern := range append(append([]string{}, rule.Tables...), rule.Methods...) {
+				if _, err := path.Match(pattern, ""); err != nil {
+					errs = append(errs, fmt.Errorf("policy: roles.%s[%d]: bad pattern %q", role, i, pattern))
+				}
+			}
+		}
+	}
+	for label, roles := range p.Bindings {
+		for _, role := range roles {
+			if _, ok := p.Roles[role]; !ok {
+				errs = append(errs, fmt.Errorf("policy: bindings.%s: unknown role %s", label, role))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rolesOf - роли из токена или из привязки метки ключа
+func (p *Policy) rolesOf(principal *Principal) []string {
+	if principal.Claims != nil {
+		return stringsClaim(principal.Claims, p.RolesClaim)
+	}
+	return p.Bindings[principal.Label]
+}
+
+// reservedRoutes - служебные маршруты, которые проверяются по политике как отдельные ресурсы
+var reservedRoutes = map[string]bool{
+	"_admin": true,
+	"_audit": true,
+	"_dump":  true,
+}
+
+// ruleMatchesTable - служебный маршрут подходит только под правило, где он назван по имени
+func ruleMatchesTable(rule PolicyRule, table string) bool {
+	if reservedRoutes[table] {
+		return containsString(rule.Tables, table)
+	}
+	return matchAny(rule.Tables, table)
+}
+
+func (p *Policy) authorize(roles []string, table, method string) accessDecision {
+	decision := accessDecision{}
+	allColumns := false
+	for _, role := range roles {
+		for _, rule := range p.Roles[role] {
+			if !ruleMatchesTable(rule, table) || !matchAny(rule.Methods, method) {
+				continue
+			}
+			if rule.Deny {
+				return accessDecision{}
+			}
+			decision.Allowed = true
+			if len(rule.Columns) == 0 {
+				allColumns = true
+			}
+			for _, col := range rule.Columns {
+				if !containsString(decision.Columns, col) {
+					decision.Columns = append(decision.Columns, col)
+				}
+			}
+		}
+	}
+	if allColumns {
+		decision.Columns = nil
+	}
+	return decision
+}
+
+// columnAllowed - первичный ключ читать можно всегда, иначе записи не с чем сопоставить
+func (d accessDecision) columnAllowed(fldInfo *FieldInfo) bool {
+	return d.Columns == nil || fldInfo.Key == "PRI" || containsString(d.Columns, fldInfo.Field)
+}
+
+// routeTable - таблица, к которой обращается запрос. /_admin/..., /_audit и /_dump возвращаются как
+// служебные ресурсы из reservedRoutes, для списка таблиц и спецификации - пустая строка, туда пускаем всех аутентифицированных
+func routeTable(urlPath string) string {
+	name := strings.Trim(urlPath, "/")
+	if slashPos := strings.Index(name, "/"); slashPos != -1 {
+		name = name[:slashPos]
+	}
+	if name == "_openapi.json" {
+		return ""
+	}
+	return name
+}
+
+type accessKey struct{}
+
+func accessFromContext(ctx context.Context) (accessDecision, bool) {
+	d, ok := ctx.Value(accessKey{}).(accessDecision)
+	return d, ok
+}
+
+// authorize проверяет запрос по политике до того, как он попадёт в обработчик.
+// Тело PUT и POST читается здесь же, чтобы проверить переданные колонки, и подкладывается обратно.
+// Тело импорта не читается: оно может быть большим, колонки каждой строки проверяет checkImportColumns
+func (e *DbExplorer) authorize(r *http.Request, principal *Principal) (*http.Request, error) {
+	table := routeTable(r.URL.Path)
+	if table == "" {
+		return r, nil
+	}
+	roles := e.policy.rolesOf(principal)
+	decision := e.policy.authorize(roles, table, r.Method)
+	if !decision.Allowed {
+		return r, fmt.Errorf("access denied: %s %s is not allowed for roles %v", r.Method, table, roles)
+	}
+	if decision.Columns != nil && (r.Method == http.MethodPut || r.Method == http.MethodPost) && !strings.HasSuffix(r.URL.Path, "/_import") {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return r, err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		record := make(map[string]interface{})
+		if json.Unmarshal(body, &record) == nil {
+			if tableInfo, ok := e.tableInfo(table); ok {
+				for _, fldInfo := range tableInfo.Fields {
+					if _, exists := record[fldInfo.Field]; exists && !decision.columnAllowed(fldInfo) {
+						return r, fmt.Errorf("access denied: column %s of %s is not writable for roles %v", fldInfo.Field, table, roles)
+					}
+				}
+			}
+		}
+	}
+	return r.WithContext(context.WithValue(r.Context(), accessKey{}, decision)), nil
+}
+
+// tableInfoFor - таблица такой, какой её видит автор запроса: без колонок, закрытых политикой
+func (e *DbExplorer) tableInfoFor(r *http.Request, name string) (*TableInfo, bool) {
+	tableInfo, exists := e.tableInfo(name)
+	if !exists {
+		return nil, false
+	}
+	return e.tableView(r.Context(), tableInfo), true
+}
+
+// tableView - то же, что tableInfoFor, для уже найденной таблицы
+func (e *DbExplorer) tableView(ctx context.Context, tableInfo *TableInfo) *TableInfo {
+	decision, ok := accessFromContext(ctx)
+	if !ok {
+		return tableInfo
+	}
+	return restrictTable(tableInfo, decision)
+}
+
+// restrictTable оставляет в таблице колонки, разрешённые решением политики
+func restrictTable(tableInfo *TableInfo, decision accessDecision) *TableInfo {
+	if decision.Columns == nil {
+		return tableInfo
+	}
+	view := &TableInfo{
+		TableName:        tableInfo.TableName,
+		Fields:           make([]*FieldInfo, 0, len(tableInfo.Fields)),
+		RowPolicy:        tableInfo.RowPolicy,
+		SoftDelete:       tableInfo.SoftDelete,
+		HasHiddenColumns: tableInfo.HasHiddenColumns,
+	}
+	for _, fldInfo := range tableInfo.Fields {
+		if decision.columnAllowed(fldInfo) {
+			view.Fields = append(view.Fields, fldInfo)
+		}
+	}
+	return view
+}
+
+// visibleTables - таблицы, которые автор запроса может читать
+func (e *DbExplorer) visibleTables(r *http.Request, tables []string) []string {
+	principal := principalFromContext(r.Context())
+	if e.policy == nil || principal == nil {
+		return tables
+	}
+	roles := e.policy.rolesOf(principal)
+	visible := make([]string, 0, len(tables))
+	for _, name := range tables {
+		if e.policy.authorize(roles, name, http.MethodGet).Allowed {
+			visible = append(visible, name)
+		}
+	}
+	return visible
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPolicy = `
+bindings:
+  ci: [admin]
+  helpdesk: [support]
+roles:
+  admin:
+    - tables: ["*", _admin, _audit, _dump]
+      methods: ["*"]
+  operator:
+    - tables: ["*"]
+      methods: ["*"]
+  auditor:
+    - tables: [_audit]
+      methods: [GET]
+  analyst:
+    - tables: ["*"]
+      methods: [GET]
+    - tables: [users]
+      methods: ["*"]
+      deny: true
+  support:
+    - tables: [items]
+      methods: [GET, POST]
+      columns: [title, description]
+    - tables: [items]
+      methods: [GET]
+      columns: [title, id]
+`
+
+func writeTestPolicy(t *testing.T, policy string) string {
+	filePath := filepath.Join(t.TempDir(), "policy.yaml")
+	err := os.WriteFile(filePath, []byte(policy), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestPolicyAuthorize(t *testing.T) {
+	policy, err := loadPolicy(writeTestPolicy(t, testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		roles   []string
+		table   string
+		method  string
+		allowed bool
+		columns []string
+	}{
+		{[]string{"admin"}, "users", http.MethodDelete, true, nil},
+		{[]string{"admin"}, "_admin", http.MethodPost, true, nil},
+		{[]string{"admin"}, "_dump", http.MethodGet, true, nil},
+		// служебные маршруты шаблоном "*" не открываются
+		{[]string{"operator"}, "users", http.MethodDelete, true, nil},
+		{[]string{"operator"}, "_audit", http.MethodGet, false, nil},
+		{[]string{"operator"}, "_dump", http.MethodGet, false, nil},
+		{[]string{"operator"}, "_admin", http.MethodPost, false, nil},
+		{[]string{"analyst"}, "_dump", http.MethodGet, false, nil},
+		{[]string{"auditor"}, "_audit", http.MethodGet, true, nil},
+		{[]string{"auditor"}, "_dump", http.MethodGet, false, nil},
+		{[]string{"analyst"}, "items", http.MethodGet, true, nil},
+		{[]string{"analyst"}, "items", http.MethodPost, false, nil},
+		{[]string{"analyst"}, "users", http.MethodGet, false, nil},
+		// deny сильнее allow даже из другой роли
+		{[]string{"admin", "analyst"}, "users", http.MethodGet, false, nil},
+		{[]string{"support"}, "items", http.MethodPost, true, []string{"title", "description"}},
+		{[]string{"support"}, "items", http.MethodGet, true, []string{"title", "description", "id"}},
+		{[]string{"support"}, "items", http.MethodDelete, false, nil},
+		{[]string{"support", "analyst"}, "items", http.MethodGet, true, nil},
+		{[]string{"unknown"}, "items", http.MethodGet, false, nil},
+		{nil, "items", http.MethodGet, false, nil},
+	}
+	for _, c := range cases {
+		got := policy.authorize(c.roles, c.table, c.method)
+		if got.Allowed != c.allowed || !reflect.DeepEqual(got.Columns, c.columns) {
+			t.Errorf("%v %s %s: got %+v, want allowed=%v columns=%v", c.roles, c.method, c.table, got, c.allowed, c.columns)
+		}
+	}
+
+	if roles := policy.rolesOf(&Principal{Label: "ci"}); !reflect.DeepEqual(roles, []string{"admin"}) {
+		t.Errorf("bad key roles: %v", roles)
+	}
+	jwtPrincipal := &Principal{Label: "bob", Claims: map[string]interface{}{"roles": []interface{}{"support"}}}
+	if roles := policy.rolesOf(jwtPrincipal); !reflect.DeepEqual(roles, []string{"support"}) {
+		t.Errorf("bad jwt roles: %v", roles)
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	_, err := loadPolicy(writeTestPolicy(t, `
+bindings:
+  ci: [missing]
+roles:
+  broken:
+    - tables: [items]
+    - tables: ["["]
+      methods: [GET]
+      columns: [id]
+      deny: true
+`))
+	if err == nil {
+		t.Fatal("expected validation error")
+	}
+	for _, want := range []string{"unknown role missing", "tables and methods are required", "bad pattern", "deny rule can't have columns"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
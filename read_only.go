@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Режим только для чтения включается в конфиге (read_only, table_settings.$table.read_only, disabled_methods)
+// и переключается на ходу через /_admin/read_only. Изменения на ходу живут до перезапуска
+
+type writeState struct {
+	global  bool
+	tables  map[string]bool
+	methods map[string]bool
+}
+
+func newWriteState(cfg *Config) writeState {
+	state := writeState{
+		global:  cfg.ReadOnly,
+		tables:  make(map[string]bool),
+		methods: make(map[string]bool),
+	}
+	for name, tc := range cfg.TableSettings {
+		if tc.ReadOnly {
+			state.tables[name] = true
+		}
+	}
+	for _, m := range cfg.DisabledMethods {
+		state.methods[strings.ToUpper(m)] = true
+	}
+	return state
+}
+
+func isWriteMethod(method string) bool {
+	switch method {
+	case http.MethodPut, http.MethodPost, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// checkWritable возвращает текст ошибки для клиента, если запрос сейчас выполнять нельзя
+func (e *DbExplorer) checkWritable(method, table string) error {
+	e.writeMu.RLock()
+	defer e.writeMu.RUnlock()
+	switch {
+	case e.writes.methods[method]:
+		return fmt.Errorf("method %s is disabled", method)
+	case !isWriteMethod(method):
+		return nil
+	case e.writes.global:
+		return fmt.Errorf("explorer is in read-only mode")
+	case e.writes.tables[table]:
+		return fmt.Errorf("table %s is in read-only mode", table)
+	}
+	return nil
+}
+
+// readOnlyStatus - текущее состояние в том же виде, в каком его принимает POST /_admin/read_only
+func (e *DbExplorer) readOnlyStatus() map[string]interface{} {
+	e.writeMu.RLock()
+	defer e.writeMu.RUnlock()
+	tables := make([]string, 0, len(e.writes.tables))
+	for name, ro := range e.writes.tables {
+		if ro {
+			tables = append(tables, name)
+		}
+	}
+	sort.Strings(tables)
+	methods := make([]string, 0, len(e.writes.methods))
+	for m, disabled := range e.writes.methods {
+		if disabled {
+			methods = append(methods, m)
+		}
+	}
+	sort.Strings(methods)
+	return map[string]interface{}{
+		"read_only":        e.writes.global,
+		"tables":           tables,
+		"disabled_methods": methods,
+	}
+}
+
+// readOnlyUpdate - тело POST /_admin/read_only, незаданные поля не меняются.
+// tables - таблица: true включает для неё режим, false выключает
+type readOnlyUpdate struct {
+	ReadOnly        *bool           `json:"read_only"`
+	Tables          map[string]bool `json:"tables"`
+	DisabledMethods *[]string       `json:"disabled_methods"`
+}
+
+func (e *DbExplorer) handlerReadOnly(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		upd := readOnlyUpdate{}
+		err := json.NewDecoder(r.Body).Decode(&upd)
+		if err != nil {
+			sendErrResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e.writeMu.Lock()
+		if upd.ReadOnly != nil {
+			e.writes.global = *upd.ReadOnly
+		}
+		for name, ro := range upd.Tables {
+			e.writes.tables[name] = ro
+		}
+		if upd.DisabledMethods != nil {
+			e.writes.methods = make(map[string]bool)
+			for _, m := range *upd.DisabledMethods {
+				e.writes.methods[strings.ToUpper(m)] = true
+			}
+		}
+		e.writeMu.Unlock()
+		e.logRequest(r, "read-only mode changed:", e.readOnlyStatus())
+	default:
+		sendErrResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	sendResponse(w, map[string]interface{}{"response": e.readOnlyStatus()}, http.StatusOK)
+}
+
+type readRequestKey struct{}
+
+// reader - база для чтения. Если задана ReadDb, запросы GET читают из неё,
+// а чтения внутри изменяющих запросов идут в основную базу, чтобы не зависеть от отставания реплики
+func (e *DbExplorer) reader(ctx context.Context) *sql.DB {
+	if e.ReadDb != nil && ctx.Value(readRequestKey{}) != nil {
+		return e.ReadDb
+	}
+	return e.Db
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Построчная политика таблицы - условия вида tenant_id = :claims.tenant из table_settings.row_policy.
+// Значения берутся из claims токена запроса и подставляются плейсхолдерами в каждый SELECT, UPDATE и DELETE,
+// а при INSERT колонка заполняется сама. Если claim нет (или запрос пришёл без JWT), таблица недоступна
+
+var errRowPolicy = errors.New("is set by row policy")
+
+type rowPredicate struct {
+	Column string
+	// Claim - путь внутри claims: tenant или org.id
+	Claim []string
+}
+
+// rowCondition - предикат с уже подставленным значением из claims
+type rowCondition struct {
+	Column string
+	Value  interface{}
+}
+
+func parseRowPredicate(s string) (rowPredicate, error) {
+	column, value, ok := strings.Cut(s, "=")
+	column = strings.TrimSpace(column)
+	value = strings.TrimSpace(value)
+	if !ok || !isIdent(column) || !strings.HasPrefix(value, ":claims.") {
+		return rowPredicate{}, fmt.Errorf("bad row predicate %q, expected column = :claims.name", s)
+	}
+	claim := strings.Split(strings.TrimPrefix(value, ":claims."), ".")
+	for _, part := range claim {
+		if !isIdent(part) {
+			return rowPredicate{}, fmt.Errorf("bad row predicate %q, expected column = :claims.name", s)
+		}
+	}
+	return rowPredicate{Column: column, Claim: claim}, nil
+}
+
+func parseRowPolicy(predicates []string) ([]rowPredicate, error) {
+	policy := make([]rowPredicate, 0, len(predicates))
+	for _, s := range predicates {
+		p, err := parseRowPredicate(s)
+		if err != nil {
+			return nil, err
+		}
+		policy = append(policy, p)
+	}
+	return policy, nil
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// claimValue достаёт значение по пути, подходят только строки, числа и bool
+func claimValue(claims map[string]interface{}, claimPath []string) (interface{}, bool) {
+	var cur interface{} = claims
+	for _, part := range claimPath {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	switch v := cur.(type) {
+	case string, bool:
+		return v, true
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, true
+		}
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return nil, false
+}
+
+type rowFilterKey struct{}
+
+type rowFilter struct {
+	Table      string
+	Conditions []rowCondition
+}
+
+// withRowFilter подставляет claims в политику таблицы один раз на запрос
+func (e *DbExplorer) withRowFilter(r *http.Request, table string) (*http.Request, error) {
+	tableInfo, exists := e.tableInfo(table)
+	if !exists || len(tableInfo.RowPolicy) == 0 {
+		return r, nil
+	}
+	principal := principalFromContext(r.Context())
+	if principal == nil || principal.Claims == nil {
+		return r, fmt.Errorf("access denied: table %s requires a token with claims", table)
+	}
+	filter := rowFilter{Table: table, Conditions: make([]rowCondition, 0, len(tableInfo.RowPolicy))}
+	for _, p := range tableInfo.RowPolicy {
+		value, ok := claimValue(principal.Claims, p.Claim)
+		if !ok {
+			return r, fmt.Errorf("access denied: table %s requires claim %s", table, strings.Join(p.Claim, "."))
+		}
+		filter.Conditions = append(filter.Conditions, rowCondition{Column: p.Column, Value: value})
+	}
+	return r.WithContext(context.WithValue(r.Context(), rowFilterKey{}, filter)), nil
+}
+
+// rowConditions - условия для запроса к таблице. Если у таблицы есть политика, а условий в контексте нет,
+// запрос не выполняется вовсе: лучше ошибка, чем чужие строки
+func (e *DbExplorer) rowConditions(ctx context.Context, tableInfo *TableInfo) ([]rowCondition, error) {
+	if len(tableInfo.RowPolicy) == 0 {
+		return nil, nil
+	}
+	filter, ok := ctx.Value(rowFilterKey{}).(rowFilter)
+	if !ok || filter.Table != tableInfo.TableName {
+		return nil, fmt.Errorf("row policy of table %s is not resolved", tableInfo.TableName)
+	}
+	return filter.Conditions, nil
+}
+
+// rowWhere добавляет к WHERE условия политики, prefix - WHERE или AND
+func (e *DbExplorer) rowWhere(conds []rowCondition, args *sqlArgs, prefix string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(conds))
+	for _, c := range conds {
+		parts = append(parts, fmt.Sprintf("%s = %s", e.Dialect.QuoteIdent(c.Column), args.add(c.Value)))
+	}
+	return " " + prefix + " " + strings.Join(parts, " AND ")
+}
+
+func findRowCondition(conds []rowCondition, column string) (rowCondition, bool) {
+	for _, c := range conds {
+		if c.Column == column {
+			return c, true
+		}
+	}
+	return rowCondition{}, false
+}
+
+// sameRowValue сравнивает присланное значение со значением из claims. Числа из JSON приходят float64,
+// из claims - int64, из базы - int64 или []byte, поэтому сравниваются их записи в rowValueString
+func sameRowValue(a, b interface{}) bool {
+	return rowValueString(a) == rowValueString(b)
+}
+
+// rowValueString - запись значения, не зависящая от его типа: целое float64 пишется как int64 (1e6 - "1000000")
+func rowValueString(v interface{}) string {
+	switch val := v.(type) {
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1<<63 {
+			return strconv.FormatInt(int64(val), 10)
+		}
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case json.Number:
+		if n, err := val.Int64(); err == nil {
+			return strconv.FormatInt(n, 10)
+		}
+		if f, err := val.Float64(); err == nil {
+			return rowValueString(f)
+		}
+	case []byte:
+		return string(val)
+	}
+	return fmt.Sprint(v)
+}
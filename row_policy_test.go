@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseRowPredicate(t *testing.T) {
+	p, err := parseRowPredicate(" tenant_id = :claims.org.tenant ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Column != "tenant_id" || !reflect.DeepEqual(p.Claim, []string{"org", "tenant"}) {
+		t.Errorf("bad predicate: %+v", p)
+	}
+	for _, bad := range []string{"tenant_id", "tenant_id = 1", "tenant_id = :claims.", "tenant_id; drop = :claims.x", "a = :claims.b-c"} {
+		if _, err := parseRowPredicate(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestClaimValue(t *testing.T) {
+	claims := map[string]interface{}{
+		"tenant": json.Number("42"),
+		"org":    map[string]interface{}{"name": "acme"},
+		"groups": []interface{}{"a"},
+	}
+	cases := []struct {
+		path []string
+		want interface{}
+		ok   bool
+	}{
+		{[]string{"tenant"}, int64(42), true},
+		{[]string{"org", "name"}, "acme", true},
+		{[]string{"org", "missing"}, nil, false},
+		{[]string{"groups"}, nil, false},
+		{[]string{"tenant", "x"}, nil, false},
+	}
+	for _, c := range cases {
+		got, ok := claimValue(claims, c.path)
+		if ok != c.ok || got != c.want {
+			t.Errorf("%v: got %v %v, want %v %v", c.path, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestSameRowValue(t *testing.T) {
+	cases := []struct {
+		a, b interface{}
+		same bool
+	}{
+		// числа из тела JSON - float64, из claims - int64
+		{float64(1000000), int64(1000000), true},
+		{float64(1e15), int64(1e15), true},
+		{json.Number("1000000"), int64(1000000), true},
+		{[]byte("1000000"), float64(1e6), true},
+		{"1000000", float64(1e6), true},
+		{float64(1.5), int64(1), false},
+		{float64(1000001), int64(1000000), false},
+		{"rvasily", "rvasily", true},
+		{true, true, true},
+		{"acme", "other", false},
+	}
+	for _, c := range cases {
+		if got := sameRowValue(c.a, c.b); got != c.same {
+			t.Errorf("sameRowValue(%#v, %#v) = %v, want %v", c.a, c.b, got, c.same)
+		}
+	}
+}
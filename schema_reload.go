@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+)
+
+// Схема подменяется целиком: ScanTables строит новую мапу, и только потом она подставляется под мьютексом.
+// Обработчик берёт *TableInfo один раз в начале запроса и дальше работает только с ним,
+// поэтому запрос, пришедший во время перезагрузки, видит либо старую схему, либо новую, но не их смесь
+
+func (e *DbExplorer) tables() map[string]*TableInfo {
+	e.schemaMu.RLock()
+	defer e.schemaMu.RUnlock()
+	return e.TablesInfo
+}
+
+func (e *DbExplorer) tableInfo(name string) (*TableInfo, bool) {
+	e.schemaMu.RLock()
+	defer e.schemaMu.RUnlock()
+	tableInfo, exists := e.TablesInfo[name]
+	return tableInfo, exists
+}
+
+// Reload заново считывает из базы список таблиц и их полей, а заодно ключи из таблицы ключей.
+// Перезагрузки (POST /_admin/reload, сигнал и опрос контрольной суммы) выполняются по одной
+func (e *DbExplorer) Reload() error {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+	return e.reload()
+}
+
+// reload - Reload под уже взятым reloadMu
+func (e *DbExplorer) reload() error {
+	// сумма считается до чтения схемы: если схема поменяется между ними, следующая проверка увидит
+	// расхождение и перечитает её ещё раз, а не запомнит новую сумму вместе со старой схемой
+	sum, err := schemaChecksum(e.Db, e.Dialect)
+	if err != nil {
+		return err
+	}
+	tablesInfo, err := e.scanTables()
+	if err != nil {
+		return err
+	}
+	keys, err := e.loadAPIKeys()
+	if err != nil {
+		return err
+	}
+	e.schemaMu.Lock()
+	e.TablesInfo = tablesInfo
+	e.schemaSum = sum
+	e.apiKeys = keys
+	e.schemaMu.Unlock()
+	if e.cache != nil {
+		// схема могла поменяться - старые ответы могут не совпадать с новыми полями
+		e.cache.purge()
+	}
+	e.Logger.Printf("schema reloaded, %d tables", len(tablesInfo))
+	return nil
+}
+
+// schemaChecksum считает хеш описания всех колонок текущей базы
+func schemaChecksum(db *sql.DB, dialect Dialect) (string, error) {
+	rows, err := db.Query(dialect.SchemaChecksumQuery())
+	if err != nil {
+		return "", err
+	}
+	values, err := scanStrings(rows)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	for _, row := range values {
+		for _, v := range row {
+			fmt.Fprintf(hash, "%t:%d:%s;", v.Valid, len(v.String), v.String)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// reloadIfChanged перезагружает схему, только если поменялась контрольная сумма
+func (e *DbExplorer) reloadIfChanged() error {
+	e.reloadMu.Lock()
+	defer e.reloadMu.Unlock()
+	sum, err := schemaChecksum(e.Db, e.Dialect)
+	if err != nil {
+		return err
+	}
+	e.schemaMu.RLock()
+	changed := sum != e.schemaSum
+	e.schemaMu.RUnlock()
+	if !changed {
+		return nil
+	}
+	return e.reload()
+}
+
+// WatchSchema раз в interval проверяет, не поменялась ли схема базы, до отмены ctx
+func (e *DbExplorer) WatchSchema(ctx context.Context, interval time.Duration) {
+	// запрос к базе выполняется до блокировки, чтобы не держать schemaMu и не останавливать чтения
+	sum, err := schemaChecksum(e.Db, e.Dialect)
+	if err != nil {
+		e.Logger.Println(err)
+	}
+	e.schemaMu.Lock()
+	if e.schemaSum == "" {
+		e.schemaSum = sum
+	}
+	e.schemaMu.Unlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := e.reloadIfChanged()
+			if err != nil {
+				e.Logger.Println(err)
+			}
+		}
+	}
+}
+
+// ReloadOnSignal перезагружает схему при получении любого из сигналов (обычно SIGHUP)
+func (e *DbExplorer) ReloadOnSignal(ctx context.Context, sig ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sig...)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case s := <-ch:
+			e.Logger.Printf("got %s, reloading schema", s)
+			err := e.Reload()
+			if err != nil {
+				e.Logger.Println(err)
+			}
+		}
+	}
+}
+
+func (e *DbExplorer) handlerReloadSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendErrResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := e.Reload()
+	if err != nil {
+		e.logRequest(r, err)
+		sendErrResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tablesInfo := e.tables()
+	tables := make([]string, 0, len(tablesInfo))
+	for name := range tablesInfo {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	resp := map[string]interface{}{"reloaded": true, "tables": tables}
+	sendResponse(w, map[string]interface{}{"response": resp}, http.StatusOK)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reloadTestTable создаёт таблицу, которой нет в тестовой схеме, и удаляет её в конце теста
+func reloadTestTable(t *testing.T, e *DbExplorer) {
+	_, err := e.Db.Exec(`CREATE TABLE reload_extra (id integer PRIMARY KEY, title varchar(255))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		e.Db.Exec(`DROP TABLE IF EXISTS reload_extra`)
+	})
+}
+
+func newReloadTestExplorer(t *testing.T) *DbExplorer {
+	db := openTestDB()
+	PrepareTestApis(db)
+	db.Exec(`DROP TABLE IF EXISTS reload_extra`)
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestReloadEndpoint(t *testing.T) {
+	handler := newReloadTestExplorer(t)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	reloadTestTable(t, handler)
+	if _, exists := handler.tableInfo("reload_extra"); exists {
+		t.Fatal("new table must not be visible before reload")
+	}
+
+	resp, err := client.Get(ts.URL + "/_admin/reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /_admin/reload: got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Post(ts.URL+"/_admin/reload", "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Response struct {
+			Reloaded bool     `json:"reloaded"`
+			Tables   []string `json:"tables"`
+		} `json:"response"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Response.Reloaded || !containsString(result.Response.Tables, "reload_extra") {
+		t.Errorf("bad reload response %+v", result.Response)
+	}
+	if _, exists := handler.tableInfo("reload_extra"); !exists {
+		t.Error("new table must be visible after reload")
+	}
+}
+
+func TestReloadIfChanged(t *testing.T) {
+	handler := newReloadTestExplorer(t)
+	if err := handler.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	before := handler.tables()
+	if err := handler.reloadIfChanged(); err != nil {
+		t.Fatal(err)
+	}
+	if after := handler.tables(); len(after) != len(before) || after["items"] != before["items"] {
+		t.Error("schema must not be reloaded while checksum is the same")
+	}
+
+	reloadTestTable(t, handler)
+	if err := handler.reloadIfChanged(); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := handler.tableInfo("reload_extra"); !exists {
+		t.Error("schema must be reloaded after checksum change")
+	}
+}
+
+func TestWatchSchema(t *testing.T) {
+	handler := newReloadTestExplorer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		handler.WatchSchema(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// первая проверка запоминает текущую сумму, изменение после неё должно быть замечено
+	time.Sleep(30 * time.Millisecond)
+	reloadTestTable(t, handler)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, exists := handler.tableInfo("reload_extra"); exists {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("poller did not pick up the new table")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestReloadConcurrent - перезагрузки из разных источников и запросы идут одновременно, запускать с -race
+func TestReloadConcurrent(t *testing.T) {
+	handler := newReloadTestExplorer(t)
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 4; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if err := handler.Reload(); err != nil {
+					errs <- err
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if err := handler.reloadIfChanged(); err != nil {
+					errs <- err
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				resp, err := client.Get(ts.URL + "/items/1")
+				if err != nil {
+					errs <- err
+					continue
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("GET /items/1 during reload: got %d", resp.StatusCode)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if _, exists := handler.tableInfo("items"); !exists {
+		t.Error("items must survive concurrent reloads")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Мягкое удаление включается в table_settings.$table.soft_delete - имя колонки-отметки.
+// Колонка с датой (deleted_at) при удалении получает текущее время, у живой записи она NULL;
+// целая или булева колонка (is_deleted) - 1/true и 0/false соответственно.
+// Удалённые записи не отдаются, пока не попросят ?include_deleted=true, вернуть запись - POST /$table/$id/_restore
+
+type softDelete struct {
+	Column string
+	// Flag - колонка-флаг, а не время удаления
+	Flag bool
+	// Bool - флаг булева типа (PostgreSQL), иначе целое
+	Bool bool
+}
+
+func newSoftDelete(fldInfo *FieldInfo) *softDelete {
+	sd := &softDelete{Column: fldInfo.Field}
+	switch base := baseType(strings.ToLower(fldInfo.Type)); {
+	case base == "bool" || base == "boolean":
+		sd.Flag, sd.Bool = true, true
+	case fldInfo.columnType().Kind == kindInteger:
+		sd.Flag = true
+	}
+	return sd
+}
+
+// liveValue - значение колонки у неудалённой записи, nil для даты
+func (sd *softDelete) liveValue() interface{} {
+	switch {
+	case sd.Bool:
+		return false
+	case sd.Flag:
+		return 0
+	}
+	return nil
+}
+
+func (sd *softDelete) deletedValue() interface{} {
+	switch {
+	case sd.Bool:
+		return true
+	case sd.Flag:
+		return 1
+	}
+	return time.Now().UTC().Format("2006-01-02 15:04:05")
+}
+
+// softDeleteCondition - условие на живые (deleted false) или удалённые записи
+func (e *DbExplorer) softDeleteCondition(sd *softDelete, args *sqlArgs, deleted bool) string {
+	column := e.Dialect.QuoteIdent(sd.Column)
+	switch {
+	case !sd.Flag && deleted:
+		return column + " IS NOT NULL"
+	case !sd.Flag:
+		return column + " IS NULL"
+	case deleted:
+		return fmt.Sprintf("%s <> %s", column, args.add(sd.liveValue()))
+	}
+	return fmt.Sprintf("%s = %s", column, args.add(sd.liveValue()))
+}
+
+type includeDeletedKey struct{}
+
+// withIncludeDeleted разбирает ?include_deleted, по умолчанию удалённые записи скрыты
+func withIncludeDeleted(r *http.Request) (*http.Request, error) {
+	query := r.URL.Query()
+	if !query.Has("include_deleted") {
+		return r, nil
+	}
+	include, err := strconv.ParseBool(query.Get("include_deleted"))
+	if err != nil {
+		return r, errors.New("bad include_deleted value")
+	}
+	if !include {
+		return r, nil
+	}
+	return r.WithContext(context.WithValue(r.Context(), includeDeletedKey{}, true)), nil
+}
+
+// softDeleteWhere добавляет к запросу условие "запись не удалена", prefix - WHERE или AND
+func (e *DbExplorer) softDeleteWhere(ctx context.Context, tableInfo *TableInfo, args *sqlArgs, prefix string) string {
+	if tableInfo.SoftDelete == nil || ctx.Value(includeDeletedKey{}) != nil {
+		return ""
+	}
+	return " " + prefix + " " + e.softDeleteCondition(tableInfo.SoftDelete, args, false)
+}
+
+// setDeleted помечает запись удалённой (deleted true) или восстанавливает её внутри транзакции
+func (e *DbExplorer) setDeleted(ctx context.Context, tx *writeTx, tableInfo *TableInfo, id int64, deleted bool) (int64, error) {
+	conds, err := e.rowConditions(ctx, tableInfo)
+	if err != nil {
+		return 0, err
+	}
+	sd := tableInfo.SoftDelete
+	value := sd.liveValue()
+	if deleted {
+		value = sd.deletedValue()
+	}
+	args := sqlArgs{dialect: e.Dialect}
+	pkName := tableInfo.findPrimKeyName()
+	query := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s = %s AND %s",
+		e.Dialect.QuoteIdent(tableInfo.TableName), e.Dialect.QuoteIdent(sd.Column), args.add(value),
+		e.Dialect.QuoteIdent(*pkName), args.add(id), e.softDeleteCondition(sd, &args, !deleted))
+	query += e.rowWhere(conds, &args, "AND")
+	res, err := tx.ExecContext(ctx, query, args.values...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (e *DbExplorer) restoreRecordById(ctx context.Context, tableInfo *TableInfo, id int64) (*int64, error) {
+	var affected int64
+	err := e.inTx(ctx, func(tx *writeTx) error {
+		var err error
+		affected, err = e.setDeleted(ctx, tx, tableInfo, id, false)
+		if err != nil || affected == 0 {
+			return err
+		}
+		return e.trackChange(ctx, tx, tableInfo, opRestore, id, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &affected, nil
+}
+
+func (e *DbExplorer) handlerRestoreRecord(tableName string, queryId string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tableInfo, exists := e.tableInfo(tableName)
+		if !exists {
+			sendErrResponse(w, "unknown table", http.StatusNotFound)
+			return
+		}
+		if tableInfo.SoftDelete == nil {
+			sendErrResponse(w, "soft delete is disabled for this table", http.StatusNotFound)
+			return
+		}
+		id, err := strconv.ParseInt(queryId, 10, 64)
+		if err != nil {
+			sendErrResponse(w, "bad id value", http.StatusBadRequest)
+			return
+		}
+		restored, err := e.restoreRecordById(r.Context(), tableInfo, id)
+		if err != nil {
+			e.logRequest(r, err)
+			sendErrResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sendResponse(w, map[string]interface{}{"response": map[string]interface{}{"restored": restored}}, http.StatusOK)
+	}
+}